@@ -22,6 +22,6 @@ kubectl curl -i http://httpbin/ip
 kubectl curl -v -n foo -- -i http://httpbin/ip`,
 	}
 	if err := cli.InitAndExecute(c); err != nil {
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 }
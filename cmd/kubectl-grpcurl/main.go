@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/michal-kopczynski/kubectl-curl/pkg/cli"
@@ -23,7 +22,6 @@ kubectl grpcurl -d {"greeting":"world"} -plaintext grpcbin:80 hello.HelloService
 kubectl grpcurl -v -n foo -- -d {"greeting":"world"} -plaintext grpcbin:80 hello.HelloService.SayHello`,
 	}
 	if err := cli.InitAndExecute(c); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 }
@@ -0,0 +1,358 @@
+package apis
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+	"k8s.io/kubectl/pkg/scheme"
+)
+
+// ExitError is returned by ExecuteCommand when the remote command terminates
+// with a non-zero exit code.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command terminated with exit code %d", e.Code)
+}
+
+// Container is a running container of a pod in which commands can be
+// executed and to and from which files can be copied.
+type Container struct {
+	clientset *kubernetes.Clientset
+	config    *rest.Config
+	logger    *log.Logger
+	namespace string
+	pod       string
+	name      string
+	utils     string
+	// shell caches whether the container has a shell, see hasShell.
+	shell *bool
+}
+
+func NewContainer(clientset *kubernetes.Clientset, config *rest.Config, logger *log.Logger, namespace string, pod string, name string) *Container {
+	return &Container{
+		clientset: clientset,
+		config:    config,
+		logger:    logger,
+		namespace: namespace,
+		pod:       pod,
+		name:      name,
+	}
+}
+
+// UseUtils makes the container run the helper commands used to copy files
+// and terminate commands, like tar or sh, as applets of the multi-call binary
+// utils, i.e. busybox, for images without them.
+func (c *Container) UseUtils(utils string) {
+	c.utils = utils
+}
+
+// utilsCommand returns the helper command with args.
+func (c *Container) utilsCommand(args ...string) []string {
+	if c.utils == "" {
+		return args
+	}
+	return append([]string{c.utils}, args...)
+}
+
+func (c *Container) String() string {
+	if c.name == c.pod {
+		return fmt.Sprintf("\"%s\" pod", c.pod)
+	}
+	return fmt.Sprintf("\"%s\" container of \"%s\" pod", c.name, c.pod)
+}
+
+// ExecuteCommand runs command in the container, streaming its standard
+// output and error to stdout and stderr as they are produced. When stdin is
+// not nil it is attached to the standard input of the command. When ctx is
+// cancelled or timeout elapses, the remote command is terminated.
+func (c *Container) ExecuteCommand(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer, timeout time.Duration) error {
+	execCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	c.logger.Printf("Executing: %s", strings.Join(command, " "))
+
+	pid := &pidWriter{w: stderr}
+	if c.hasShell(execCtx) {
+		command = append(c.utilsCommand("sh", "-c", wrapperScript, "sh"), command...)
+		stderr = pid
+	}
+
+	err := c.stream(execCtx, command, stdin, stdout, stderr)
+	if execCtx.Err() != nil {
+		c.terminate(pid.PID())
+		if ctx.Err() != nil {
+			return fmt.Errorf("command cancelled: %w", ctx.Err())
+		}
+		return fmt.Errorf("command timed out after %s", timeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.logger.Println("Command executed successfully.")
+
+	return nil
+}
+
+// stream runs command in the container until it exits or ctx is done.
+func (c *Container) stream(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	execRequest := c.clientset.CoreV1().RESTClient().
+		Post().
+		Resource("pods").
+		Name(c.pod).
+		Namespace(c.namespace).
+		SubResource("exec").
+		VersionedParams(&apiv1.PodExecOptions{
+			Container: c.name,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(c.config, "POST", execRequest.URL())
+	if err != nil {
+		return fmt.Errorf("Failed to initialize command executor: %w", err)
+	}
+
+	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+	if err != nil {
+		var codeExitErr utilexec.ExitError
+		if errors.As(err, &codeExitErr) && codeExitErr.Exited() {
+			c.logger.Printf("Command failed: %s\n", err)
+			return &ExitError{Code: codeExitErr.ExitStatus()}
+		}
+		return fmt.Errorf("failed to execute command: %w", err)
+	}
+
+	return nil
+}
+
+// terminateTimeout bounds the termination of a remote command, which is run
+// after the command context is already done.
+const terminateTimeout = 5 * time.Second
+
+// pidPrefix prefixes the line with the PID of the remote command which the
+// wrapper shell writes to the standard error before running the command.
+const pidPrefix = "kubectl-curl-pid:"
+
+// wrapperScript reports the PID of the shell and replaces the shell with the
+// command, which keeps the PID, so that only the process of this invocation
+// is terminated, also when other invocations run the same command in a
+// shared pod.
+const wrapperScript = `echo "` + pidPrefix + `$$" >&2; exec "$@"`
+
+// hasShell reports whether commands can be run through the wrapper shell,
+// checking it once per container.
+func (c *Container) hasShell(ctx context.Context) bool {
+	if c.shell == nil {
+		ctx, cancel := context.WithTimeout(ctx, terminateTimeout)
+		defer cancel()
+		found := c.stream(ctx, c.utilsCommand("sh", "-c", "exit 0"), nil, io.Discard, io.Discard) == nil
+		if !found && ctx.Err() != nil {
+			return false
+		}
+		if !found {
+			c.logger.Printf("No shell found in %s, commands can not be terminated.\n", c)
+		}
+		c.shell = &found
+	}
+	return *c.shell
+}
+
+// pidWriter passes the standard error of the wrapped command to w, except
+// its first line holding the PID of the command.
+type pidWriter struct {
+	w    io.Writer
+	mu   sync.Mutex
+	line []byte
+	done bool
+	pid  string
+}
+
+func (p *pidWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done {
+		return p.w.Write(b)
+	}
+	p.line = append(p.line, b...)
+	line, rest, found := bytes.Cut(p.line, []byte("\n"))
+	if !found {
+		return len(b), nil
+	}
+	p.done = true
+	if pid, ok := bytes.CutPrefix(line, []byte(pidPrefix)); ok {
+		p.pid = string(pid)
+	} else {
+		rest = p.line
+	}
+	if _, err := p.w.Write(rest); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// PID returns the PID of the remote command, or an empty string when it has
+// not started.
+func (p *pidWriter) PID() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pid
+}
+
+// terminate stops the remote command with pid, which keeps running when its
+// exec stream is closed. It only logs failures.
+func (c *Container) terminate(pid string) {
+	if pid == "" {
+		c.logger.Println("Not terminating the remote command, its process is unknown.")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), terminateTimeout)
+	defer cancel()
+
+	kill := c.utilsCommand("sh", "-c", `kill -TERM "$0"`, pid)
+	if err := c.stream(ctx, kill, nil, io.Discard, io.Discard); err != nil {
+		c.logger.Printf("Failed to terminate the remote command: %s\n", err)
+		return
+	}
+	c.logger.Println("Remote command terminated.")
+}
+
+// CopyTo uploads local files into dir inside the container by
+// streaming a tar archive to "tar xf -", the same way "kubectl cp" does.
+// files maps names relative to dir to local file paths.
+func (c *Container) CopyTo(ctx context.Context, files map[string]string, dir string, timeout time.Duration) error {
+	if err := c.MakeDir(ctx, dir, timeout); err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTar(writer, files))
+	}()
+
+	stderr := &strings.Builder{}
+	if err := c.ExecuteCommand(ctx, c.utilsCommand("tar", "xf", "-", "-C", dir), reader, io.Discard, stderr, timeout); err != nil {
+		return fmt.Errorf("failed to copy files to pod: %w: %s", err, stderr.String())
+	}
+
+	return nil
+}
+
+// CopyFrom downloads the contents of dir inside the container by
+// reading a tar archive produced by "tar cf -" and calls handle for every
+// regular file found, with its name relative to dir.
+func (c *Container) CopyFrom(ctx context.Context, dir string, handle func(name string, r io.Reader) error, timeout time.Duration) error {
+	reader, writer := io.Pipe()
+	stderr := &strings.Builder{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writer.CloseWithError(c.ExecuteCommand(ctx, c.utilsCommand("tar", "cf", "-", "-C", dir, "."), nil, writer, stderr, timeout))
+	}()
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			reader.CloseWithError(err)
+			<-done
+			return fmt.Errorf("failed to read files from pod: %w: %s", err, stderr.String())
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := handle(path.Clean(header.Name), tr); err != nil {
+			reader.CloseWithError(err)
+			<-done
+			return err
+		}
+	}
+
+	_, err := io.Copy(io.Discard, reader)
+	<-done
+	if err != nil {
+		return fmt.Errorf("failed to copy files from pod: %w: %s", err, stderr.String())
+	}
+
+	return nil
+}
+
+// MakeDir creates dir, including any missing parents, inside the container.
+func (c *Container) MakeDir(ctx context.Context, dir string, timeout time.Duration) error {
+	if err := c.ExecuteCommand(ctx, c.utilsCommand("mkdir", "-p", dir), nil, io.Discard, io.Discard, timeout); err != nil {
+		return fmt.Errorf("failed to create directory \"%s\": %w", dir, err)
+	}
+	return nil
+}
+
+// RemovePath deletes path from the container.
+func (c *Container) RemovePath(ctx context.Context, path string, timeout time.Duration) error {
+	if err := c.ExecuteCommand(ctx, c.utilsCommand("rm", "-rf", path), nil, io.Discard, io.Discard, timeout); err != nil {
+		return fmt.Errorf("failed to remove \"%s\": %w", path, err)
+	}
+	return nil
+}
+
+func writeTar(w io.Writer, files map[string]string) error {
+	tw := tar.NewWriter(w)
+	for name, localPath := range files {
+		if err := writeTarFile(tw, name, localPath); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
@@ -0,0 +1,164 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// EphemeralContainer is a debug container injected into an existing pod via
+// the pods/ephemeralcontainers subresource. It shares the network namespace
+// of the pod and, when a target container is set, its process namespace.
+// Ephemeral containers can not be removed, they stay in the pod spec until
+// the pod is deleted.
+type EphemeralContainer struct {
+	clientset       *kubernetes.Clientset
+	config          *rest.Config
+	logger          *log.Logger
+	image           string
+	pullPolicy      apiv1.PullPolicy
+	namespace       string
+	pod             string
+	name            string
+	targetContainer string
+	command         []string
+	securityContext *apiv1.SecurityContext
+}
+
+// NewEphemeralContainer returns the ephemeral container. Its image is pulled
+// with pullPolicy, or only when not present when empty.
+func NewEphemeralContainer(clientset *kubernetes.Clientset, config *rest.Config, logger *log.Logger, image string, pullPolicy apiv1.PullPolicy, namespace string, pod string, name string, targetContainer string, command []string, securityContext *apiv1.SecurityContext) *EphemeralContainer {
+	if pullPolicy == "" {
+		pullPolicy = apiv1.PullIfNotPresent
+	}
+	return &EphemeralContainer{
+		clientset:       clientset,
+		config:          config,
+		logger:          logger,
+		image:           image,
+		pullPolicy:      pullPolicy,
+		namespace:       namespace,
+		pod:             pod,
+		name:            name,
+		targetContainer: targetContainer,
+		command:         command,
+		securityContext: securityContext,
+	}
+}
+
+// Object returns the ephemeral container spec.
+func (e *EphemeralContainer) Object() apiv1.EphemeralContainer {
+	return apiv1.EphemeralContainer{
+		EphemeralContainerCommon: apiv1.EphemeralContainerCommon{
+			Name:                     e.name,
+			Image:                    e.image,
+			Command:                  e.command,
+			ImagePullPolicy:          e.pullPolicy,
+			TerminationMessagePolicy: apiv1.TerminationMessageFallbackToLogsOnError,
+			SecurityContext:          e.securityContext,
+		},
+		TargetContainerName: e.targetContainer,
+	}
+}
+
+// Create adds the ephemeral container to the pod.
+func (e *EphemeralContainer) Create(ctx context.Context) error {
+	podsClient := e.clientset.CoreV1().Pods(e.namespace)
+
+	pod, err := podsClient.Get(ctx, e.pod, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get target pod: %w", err)
+	}
+	if pod.Status.Phase != apiv1.PodRunning {
+		return fmt.Errorf("target pod is not running, its phase is %s", pod.Status.Phase)
+	}
+	if e.targetContainer == "" {
+		e.targetContainer = pod.Spec.Containers[0].Name
+	} else if !HasContainer(pod, e.targetContainer) {
+		return fmt.Errorf("container \"%s\" not found in target pod", e.targetContainer)
+	}
+
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, e.Object())
+
+	e.logger.Printf("Adding ephemeral container \"%s\" targeting container \"%s\" to pod \"%s\".\n", e.name, e.targetContainer, e.pod)
+	_, err = podsClient.UpdateEphemeralContainers(ctx, e.pod, pod, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to add ephemeral container, ephemeral containers may be disabled or not allowed by an admission controller: %w", err)
+	}
+
+	return nil
+}
+
+// HasContainer reports whether pod has a regular container named name.
+func HasContainer(pod *apiv1.Pod, name string) bool {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitForReady waits until the ephemeral container is running, failing fast
+// when it can not start or when the pod terminates, when timeout elapses or
+// when ctx is cancelled.
+func (e *EphemeralContainer) WaitForReady(ctx context.Context, timeout time.Duration) error {
+	waitCtx, cancel := watchtools.ContextWithOptionalTimeout(ctx, timeout)
+	defer cancel()
+
+	e.logger.Println("Waiting for ephemeral container to be running...")
+
+	_, err := watchtools.UntilWithSync(waitCtx, podListWatch(waitCtx, e.clientset, e.namespace, e.pod), &apiv1.Pod{}, nil, func(event watch.Event) (bool, error) {
+		if event.Type == watch.Deleted {
+			return false, fmt.Errorf("pod was deleted while waiting for the ephemeral container to be running")
+		}
+		pod, ok := event.Object.(*apiv1.Pod)
+		if !ok {
+			return false, nil
+		}
+		switch pod.Status.Phase {
+		case apiv1.PodFailed, apiv1.PodSucceeded:
+			return false, fmt.Errorf("pod terminated with phase %s", pod.Status.Phase)
+		}
+		for _, status := range pod.Status.EphemeralContainerStatuses {
+			if status.Name != e.name {
+				continue
+			}
+			if err := containerFailure(status); err != nil {
+				return false, err
+			}
+			return status.State.Running != nil, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("cancelled waiting for ephemeral container to be running: %w", ctx.Err())
+		}
+		if wait.Interrupted(err) {
+			return fmt.Errorf("timed out waiting for ephemeral container to be running%s", e.eventsSummary(ctx))
+		}
+		return fmt.Errorf("%w%s", err, e.eventsSummary(ctx))
+	}
+
+	e.logger.Println("Ephemeral container is now running.")
+	return nil
+}
+
+func (e *EphemeralContainer) eventsSummary(ctx context.Context) string {
+	return NewPod(e.clientset, e.config, e.logger, "", e.namespace, e.pod, nil, 0).eventsSummary(ctx)
+}
+
+// Container returns the ephemeral container of the pod.
+func (e *EphemeralContainer) Container() *Container {
+	return NewContainer(e.clientset, e.config, e.logger, e.namespace, e.pod, e.name)
+}
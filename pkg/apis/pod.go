@@ -2,76 +2,133 @@ package apis
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
 	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/remotecommand"
-	"k8s.io/kubectl/pkg/scheme"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
 )
 
+// SpecHashAnnotation is set on created pods to the hash of their generated
+// labels, annotations and spec, so that an existing pod can be compared with
+// the requested one.
+const SpecHashAnnotation = "kubectl-curl/spec-hash"
+
+// Mutation modifies the pod object generated by Pod before it is created.
+type Mutation func(pod *apiv1.Pod) error
+
 type Pod struct {
-	clientset *kubernetes.Clientset
-	config    *rest.Config
-	logger    *log.Logger
-	image     string
-	namespace string
-	name      string
-	command   []string
-	port      int32
+	clientset     *kubernetes.Clientset
+	config        *rest.Config
+	logger        *log.Logger
+	image         string
+	namespace     string
+	name          string
+	containerName string
+	generateName  bool
+	command       []string
+	port          int32
+	mutations     []Mutation
+	// volatileAnnotations are not hashed, see IgnoreInHash.
+	volatileAnnotations []string
 }
 
 func NewPod(clientset *kubernetes.Clientset, config *rest.Config, logger *log.Logger, image string, namespace string, name string, command []string, port int32) *Pod {
 	return &Pod{
-		clientset: clientset,
-		config:    config,
-		logger:    logger,
-		image:     image,
-		namespace: namespace,
-		name:      name,
-		command:   command,
-		port:      port,
+		clientset:     clientset,
+		config:        config,
+		logger:        logger,
+		image:         image,
+		namespace:     namespace,
+		name:          name,
+		containerName: name,
+		command:       command,
+		port:          port,
 	}
 }
 
-func (p *Pod) IsCreated() (bool, error) {
+// IgnoreInHash excludes the annotations with keys, whose generated values
+// differ every time, like timestamps, from the spec hash.
+func (p *Pod) IgnoreInHash(keys ...string) {
+	p.volatileAnnotations = append(p.volatileAnnotations, keys...)
+}
+
+// Name returns the pod name. With GenerateName it is known only after the
+// pod is created.
+func (p *Pod) Name() string {
+	return p.name
+}
+
+// GenerateName makes Create submit the pod with a unique name generated by
+// the API server from the pod name.
+func (p *Pod) GenerateName() {
+	p.generateName = true
+}
+
+// Get returns the pod object from the cluster or nil when it does not exist.
+func (p *Pod) Get(ctx context.Context) (*apiv1.Pod, error) {
 	podsClient := p.clientset.CoreV1().Pods(p.namespace)
 
-	_, err := podsClient.Get(context.TODO(), p.name, metav1.GetOptions{})
+	pod, err := podsClient.Get(ctx, p.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			return false, nil
-		}
-		return false, err
+		return nil, err
 	}
 
-	return true, nil
+	return pod, nil
 }
 
-func (p *Pod) Create() error {
-	podsClient := p.clientset.CoreV1().Pods(p.namespace)
+// AddMutation registers m to be applied to the generated pod object after
+// the previously registered mutations.
+func (p *Pod) AddMutation(m Mutation) {
+	p.mutations = append(p.mutations, m)
+}
 
+// Object returns the pod object which Create submits to the cluster.
+func (p *Pod) Object() (*apiv1.Pod, error) {
 	pod := &apiv1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: p.name,
+			Name:      p.name,
+			Namespace: p.namespace,
 			Labels: map[string]string{
-				"app": p.name,
+				"app": p.containerName,
 			},
+			Annotations: map[string]string{},
 		},
 		Spec: apiv1.PodSpec{
 			Containers: []apiv1.Container{
 				{
-					Name:  p.name,
+					Name:  p.containerName,
 					Image: p.image,
 				},
 			},
 		},
 	}
+	if p.generateName {
+		pod.Name = ""
+		pod.GenerateName = p.name + "-"
+	}
 
 	if len(p.command) != 0 {
 		pod.Spec.Containers[0].Command = p.command
@@ -85,87 +142,405 @@ func (p *Pod) Create() error {
 		}
 	}
 
-	_, err := podsClient.Create(context.TODO(), pod, metav1.CreateOptions{})
+	for _, mutate := range p.mutations {
+		if err := mutate(pod); err != nil {
+			return nil, err
+		}
+	}
+
+	hash, err := SpecHash(pod, p.volatileAnnotations)
+	if err != nil {
+		return nil, err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[SpecHashAnnotation] = hash
+
+	return pod, nil
+}
+
+// SpecHash returns a hash of the labels, the annotations except the
+// ignored ones and the spec of pod.
+func SpecHash(pod *apiv1.Pod, ignoredAnnotations []string) (string, error) {
+	annotations := map[string]string{}
+	for key, value := range pod.Annotations {
+		if key != SpecHashAnnotation && !slices.Contains(ignoredAnnotations, key) {
+			annotations[key] = value
+		}
+	}
+	data, err := json.Marshal(struct {
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+		Spec        apiv1.PodSpec     `json:"spec"`
+	}{pod.Labels, annotations, pod.Spec})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash pod spec: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func (p *Pod) Create(ctx context.Context) error {
+	podsClient := p.clientset.CoreV1().Pods(p.namespace)
+
+	pod, err := p.Object()
 	if err != nil {
+		return fmt.Errorf("failed to generate pod: %w", err)
+	}
 
+	created, err := podsClient.Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		if apierrors.IsForbidden(err) || apierrors.IsInvalid(err) {
+			return fmt.Errorf("pod rejected by the API server or an admission controller: %w", err)
+		}
 		return fmt.Errorf("failed to create pod: %w", err)
 	}
+	p.name = created.Name
+	p.generateName = false
 
 	p.logger.Printf("Pod \"%s\" created successfully in namespace \"%s\".\n", p.name, p.namespace)
 
 	return nil
 }
 
-func (p *Pod) WaitForReady(timeout time.Duration) error {
-	watch, err := p.clientset.CoreV1().Pods(p.namespace).Watch(context.TODO(), metav1.ListOptions{
-		FieldSelector: "metadata.name=" + p.name,
+// WaitForReady waits until the pod and all its containers are running. It
+// fails early when the pod can never become ready, when it is deleted, when
+// timeout elapses or when ctx is cancelled.
+func (p *Pod) WaitForReady(ctx context.Context, timeout time.Duration) error {
+	p.logger.Println("Waiting for pod to be ready...")
+
+	var nodeName string
+	err := p.waitFor(ctx, timeout, "pod to be ready", func(pod *apiv1.Pod) (bool, error) {
+		if err := podFailure(pod); err != nil {
+			return false, err
+		}
+		nodeName = pod.Spec.NodeName
+		return isRunning(pod), nil
 	})
 	if err != nil {
-		return fmt.Errorf("Failed to watch pod: %w", err)
+		return err
 	}
 
-	timeoutChan := time.After(timeout)
-	p.logger.Println("Waiting for pod to be ready...")
+	p.logger.Printf("Pod is now running on node \"%s\".\n", nodeName)
+	return nil
+}
+
+// WaitForStart waits until the pod container is running or has already
+// terminated, failing early when it can never start.
+func (p *Pod) WaitForStart(ctx context.Context, timeout time.Duration) error {
+	p.logger.Println("Waiting for pod to start...")
+
+	return p.waitFor(ctx, timeout, "pod to start", func(pod *apiv1.Pod) (bool, error) {
+		if status := containerStatus(pod, p.containerName); status != nil && (status.State.Running != nil || status.State.Terminated != nil) {
+			return true, nil
+		}
+		return false, podFailure(pod)
+	})
+}
+
+// startFailureReasons lists the reasons of terminated containers whose
+// command never ran.
+var startFailureReasons = []string{"StartError", "ContainerCannotRun"}
 
-	for {
-		select {
-		case event := <-watch.ResultChan():
-			pod, ok := event.Object.(*apiv1.Pod)
-			if !ok {
-				return fmt.Errorf("unexpected type in watch event")
-			}
-			if pod.Status.Phase == apiv1.PodRunning {
-				p.logger.Println("Pod is now running.")
-				return nil
-			}
-		case <-timeoutChan:
-			return fmt.Errorf("timed out waiting for pod to be ready")
-		}
-	}
-}
-
-func (p *Pod) ExecuteCommand(command string, timeout time.Duration) (string, error) {
-	execRequest := p.clientset.CoreV1().RESTClient().
-		Post().
-		Resource("pods").
-		Name(p.name).
-		Namespace(p.namespace).
-		SubResource("exec").
-		VersionedParams(&apiv1.PodExecOptions{
-			Container: p.name,
-			Command:   strings.Split(command, " "),
-			Stdout:    true,
-			Stderr:    true,
-		}, scheme.ParameterCodec)
-
-	exec, err := remotecommand.NewSPDYExecutor(p.config, "POST", execRequest.URL())
+// WaitForTermination waits until the pod container has terminated and
+// returns its terminated state. A container whose command could not be
+// started is reported as an error, wrapping ErrCommandNotFound when the
+// command does not exist.
+func (p *Pod) WaitForTermination(ctx context.Context, timeout time.Duration) (*apiv1.ContainerStateTerminated, error) {
+	var terminated *apiv1.ContainerStateTerminated
+	err := p.waitFor(ctx, timeout, "pod to terminate", func(pod *apiv1.Pod) (bool, error) {
+		status := containerStatus(pod, p.containerName)
+		if status == nil || status.State.Terminated == nil {
+			return false, nil
+		}
+		if slices.Contains(startFailureReasons, status.State.Terminated.Reason) || commandNotFound(*status) {
+			return false, containerFailure(*status)
+		}
+		terminated = status.State.Terminated
+		return true, nil
+	})
+	return terminated, err
+}
+
+// StreamLogs follows the logs of the pod container and writes them to out
+// until the container terminates or ctx is cancelled.
+func (p *Pod) StreamLogs(ctx context.Context, out io.Writer) error {
+	stream, err := p.clientset.CoreV1().Pods(p.namespace).GetLogs(p.name, &apiv1.PodLogOptions{
+		Container: p.containerName,
+		Follow:    true,
+	}).Stream(ctx)
 	if err != nil {
-		return "", fmt.Errorf("Failed to initialize command executor: %w", err)
+		return fmt.Errorf("failed to stream logs: %w", err)
 	}
+	defer stream.Close()
 
-	output := &strings.Builder{}
-	errorOutput := &strings.Builder{}
+	if _, err := io.Copy(out, stream); err != nil {
+		return fmt.Errorf("failed to stream logs: %w", err)
+	}
+	return nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// waitFor waits until condition is met by the pod, describing what is waited
+// for in errors. It fails when condition returns an error, when the pod is
+// deleted, when timeout elapses or when ctx is cancelled.
+func (p *Pod) waitFor(ctx context.Context, timeout time.Duration, what string, condition func(pod *apiv1.Pod) (bool, error)) error {
+	waitCtx, cancel := watchtools.ContextWithOptionalTimeout(ctx, timeout)
 	defer cancel()
 
-	p.logger.Printf("Executing: %s", command)
+	_, err := watchtools.UntilWithSync(waitCtx, podListWatch(waitCtx, p.clientset, p.namespace, p.name), &apiv1.Pod{}, nil, func(event watch.Event) (bool, error) {
+		if event.Type == watch.Deleted {
+			return false, fmt.Errorf("pod was deleted while waiting for %s", what)
+		}
+		pod, ok := event.Object.(*apiv1.Pod)
+		if !ok {
+			return false, nil
+		}
+		return condition(pod)
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("cancelled waiting for %s: %w", what, ctx.Err())
+		}
+		if wait.Interrupted(err) {
+			return fmt.Errorf("timed out waiting for %s%s", what, p.eventsSummary(ctx))
+		}
+		return fmt.Errorf("%w%s", err, p.eventsSummary(ctx))
+	}
+	return nil
+}
+
+func containerStatus(pod *apiv1.Pod, name string) *apiv1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == name {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
 
-	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
-		Stdout: output,
-		Stderr: errorOutput,
+// WaitForDeletion waits until the pod no longer exists, failing when timeout
+// elapses or when ctx is cancelled.
+func (p *Pod) WaitForDeletion(ctx context.Context, timeout time.Duration) error {
+	waitCtx, cancel := watchtools.ContextWithOptionalTimeout(ctx, timeout)
+	defer cancel()
+
+	p.logger.Println("Waiting for pod to be deleted...")
+
+	precondition := func(store cache.Store) (bool, error) {
+		_, exists, err := store.GetByKey(p.namespace + "/" + p.name)
+		return !exists, err
+	}
+	_, err := watchtools.UntilWithSync(waitCtx, podListWatch(waitCtx, p.clientset, p.namespace, p.name), &apiv1.Pod{}, precondition, func(event watch.Event) (bool, error) {
+		return event.Type == watch.Deleted, nil
 	})
 	if err != nil {
-		p.logger.Printf("Command failed: %s\n", err)
-		return errorOutput.String(), nil
+		if ctx.Err() != nil {
+			return fmt.Errorf("cancelled waiting for pod to be deleted: %w", ctx.Err())
+		}
+		if wait.Interrupted(err) {
+			return fmt.Errorf("timed out waiting for pod to be deleted")
+		}
+		return err
+	}
+
+	p.logger.Println("Pod is now deleted.")
+	return nil
+}
+
+// podListWatch returns a ListWatch of the single pod with name in namespace.
+func podListWatch(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string) *cache.ListWatch {
+	podsClient := clientset.CoreV1().Pods(namespace)
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", name).String()
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return podsClient.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return podsClient.Watch(ctx, options)
+		},
+	}
+}
+
+// fatalWaitingReasons lists the container waiting reasons after which the
+// pod can not become ready without changes to its spec or cluster state.
+var fatalWaitingReasons = []string{
+	"ErrImagePull",
+	"ImagePullBackOff",
+	"InvalidImageName",
+	"ErrImageNeverPull",
+	"CrashLoopBackOff",
+	"CreateContainerConfigError",
+	"CreateContainerError",
+	"RunContainerError",
+}
+
+// podFailure returns a descriptive error when pod can never become ready.
+func podFailure(pod *apiv1.Pod) error {
+	switch pod.Status.Phase {
+	case apiv1.PodFailed, apiv1.PodSucceeded:
+		return fmt.Errorf("pod terminated with phase %s: %s", pod.Status.Phase, statusMessage(pod.Status.Reason, pod.Status.Message))
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if terminated := status.State.Terminated; terminated != nil && status.RestartCount == 0 {
+			continue
+		}
+		if err := containerFailure(status); err != nil {
+			return err
+		}
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == apiv1.PodScheduled && condition.Status == apiv1.ConditionFalse && condition.Reason == apiv1.PodReasonUnschedulable {
+			return fmt.Errorf("pod can not be scheduled: %s", condition.Message)
+		}
+	}
+
+	return nil
+}
+
+// ErrCommandNotFound is wrapped by the errors of containers whose command
+// does not exist in their image.
+var ErrCommandNotFound = errors.New("container command not found in the image")
+
+// containerFailure returns a descriptive error when the container described
+// by status can not start or has terminated.
+func containerFailure(status apiv1.ContainerStatus) error {
+	var err error
+	if waiting := status.State.Waiting; waiting != nil && slices.Contains(fatalWaitingReasons, waiting.Reason) {
+		err = fmt.Errorf("container \"%s\" can not start: %s", status.Name, statusMessage(waiting.Reason, waiting.Message))
+	} else if terminated := status.State.Terminated; terminated != nil {
+		err = fmt.Errorf("container \"%s\" terminated with exit code %d: %s", status.Name, terminated.ExitCode, statusMessage(terminated.Reason, terminated.Message))
+	}
+	if err != nil && commandNotFound(status) {
+		return fmt.Errorf("%w: %w", ErrCommandNotFound, err)
+	}
+	return err
+}
+
+// commandNotFound reports whether the container described by status failed
+// to start because its command does not exist, as reported by the container
+// runtime.
+func commandNotFound(status apiv1.ContainerStatus) bool {
+	var messages []string
+	if waiting := status.State.Waiting; waiting != nil {
+		messages = append(messages, waiting.Message)
+	}
+	if terminated := status.State.Terminated; terminated != nil {
+		messages = append(messages, terminated.Message)
+	}
+	if terminated := status.LastTerminationState.Terminated; terminated != nil {
+		messages = append(messages, terminated.Message)
+	}
+	for _, message := range messages {
+		if strings.Contains(message, "executable file not found") || strings.Contains(message, "no such file or directory") {
+			return true
+		}
+	}
+	return false
+}
+
+// isRunning reports whether pod and all its containers are running.
+func isRunning(pod *apiv1.Pod) bool {
+	if pod.Status.Phase != apiv1.PodRunning {
+		return false
 	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func statusMessage(reason string, message string) string {
+	if message == "" {
+		return reason
+	}
+	if reason == "" {
+		return message
+	}
+	return reason + ": " + message
+}
 
-	p.logger.Println("Command executed successfully. Output:")
+// eventsSummary returns the Kubernetes events related to the pod formatted
+// for inclusion in error messages, or an empty string when there are none.
+func (p *Pod) eventsSummary(ctx context.Context) string {
+	events, err := p.clientset.CoreV1().Events(p.namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.AndSelectors(
+			fields.OneTermEqualSelector("involvedObject.kind", "Pod"),
+			fields.OneTermEqualSelector("involvedObject.name", p.name),
+		).String(),
+	})
+	if err != nil || len(events.Items) == 0 {
+		return ""
+	}
 
-	return output.String(), nil
+	sort.Slice(events.Items, func(i, j int) bool {
+		return events.Items[i].LastTimestamp.Before(&events.Items[j].LastTimestamp)
+	})
+
+	summary := &strings.Builder{}
+	summary.WriteString("\nEvents:")
+	for _, event := range events.Items {
+		fmt.Fprintf(summary, "\n  %s\t%s\t%s", event.Type, event.Reason, event.Message)
+	}
+	return summary.String()
+}
+
+// Container returns the container of the pod.
+func (p *Pod) Container() *Container {
+	return NewContainer(p.clientset, p.config, p.logger, p.namespace, p.name, p.containerName)
+}
+
+// SetAnnotations sets the annotations of the pod to the given values, or
+// removes those with nil values, with a merge patch which does not conflict
+// with concurrent changes of other annotations. When resourceVersion is not
+// empty, the patch fails with a conflict error unless the pod still has that
+// resource version. It returns the patched pod.
+func (p *Pod) SetAnnotations(ctx context.Context, annotations map[string]*string, resourceVersion string) (*apiv1.Pod, error) {
+	metadata := map[string]interface{}{
+		"annotations": annotations,
+	}
+	if resourceVersion != "" {
+		metadata["resourceVersion"] = resourceVersion
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": metadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pod, err := p.clientset.CoreV1().Pods(p.namespace).Patch(ctx, p.name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to patch pod: %w", err)
+	}
+	return pod, nil
+}
+
+// DeleteUnchanged deletes the pod only when its resource version still is
+// resourceVersion, failing with a conflict error otherwise.
+func (p *Pod) DeleteUnchanged(ctx context.Context, resourceVersion string) error {
+	podsClient := p.clientset.CoreV1().Pods(p.namespace)
+
+	deletePolicy := metav1.DeletePropagationForeground
+	err := podsClient.Delete(ctx, p.name, metav1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+		Preconditions:     &metav1.Preconditions{ResourceVersion: &resourceVersion},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to delete pod: %w", err)
+	}
+
+	p.logger.Println("Pod deleted successfully.")
+	return nil
 }
 
-func (p *Pod) Delete() error {
+func (p *Pod) Delete(ctx context.Context) error {
 	podsClient := p.clientset.CoreV1().Pods(p.namespace)
 
 	deletePolicy := metav1.DeletePropagationForeground
@@ -173,7 +548,7 @@ func (p *Pod) Delete() error {
 		PropagationPolicy: &deletePolicy,
 	}
 
-	err := podsClient.Delete(context.TODO(), p.name, *deleteOptions)
+	err := podsClient.Delete(ctx, p.name, *deleteOptions)
 	if err != nil {
 		return fmt.Errorf("Failed to delete pod: %w", err)
 	}
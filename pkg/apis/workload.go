@@ -0,0 +1,270 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Workload is a pod or a pod controller (deployment, statefulset, daemonset
+// or replicaset) referenced as "[namespace/]kind/name", i.e. "pod/foo" or
+// "prod/deploy/bar".
+type Workload struct {
+	clientset *kubernetes.Clientset
+	logger    *log.Logger
+	kind      string
+	namespace string
+	name      string
+}
+
+// workloadKinds maps the accepted kind names and aliases to canonical kinds.
+var workloadKinds = map[string]string{
+	"pod":          "pod",
+	"pods":         "pod",
+	"po":           "pod",
+	"deployment":   "deployment",
+	"deployments":  "deployment",
+	"deploy":       "deployment",
+	"statefulset":  "statefulset",
+	"statefulsets": "statefulset",
+	"sts":          "statefulset",
+	"daemonset":    "daemonset",
+	"daemonsets":   "daemonset",
+	"ds":           "daemonset",
+	"replicaset":   "replicaset",
+	"replicasets":  "replicaset",
+	"rs":           "replicaset",
+}
+
+// NewWorkload parses ref and returns the referenced workload. Workloads
+// referenced without a namespace are looked up in defaultNamespace.
+func NewWorkload(clientset *kubernetes.Clientset, logger *log.Logger, defaultNamespace string, ref string) (*Workload, error) {
+	parts := strings.Split(ref, "/")
+	namespace := defaultNamespace
+	if len(parts) == 3 {
+		namespace = parts[0]
+		parts = parts[1:]
+	}
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid workload reference \"%s\", expected [namespace/]kind/name", ref)
+	}
+
+	kind, ok := workloadKinds[strings.ToLower(parts[0])]
+	if !ok {
+		return nil, fmt.Errorf("unsupported workload kind \"%s\" in \"%s\", expected pod, deployment, statefulset, daemonset or replicaset", parts[0], ref)
+	}
+
+	return &Workload{
+		clientset: clientset,
+		logger:    logger,
+		kind:      kind,
+		namespace: namespace,
+		name:      parts[1],
+	}, nil
+}
+
+func (w *Workload) Kind() string {
+	return w.kind
+}
+
+func (w *Workload) Namespace() string {
+	return w.namespace
+}
+
+func (w *Workload) Name() string {
+	return w.name
+}
+
+func (w *Workload) String() string {
+	return w.namespace + "/" + w.kind + "/" + w.name
+}
+
+// Template returns the pod template of the workload. For a pod it is built
+// from the pod metadata and spec.
+func (w *Workload) Template(ctx context.Context) (*apiv1.PodTemplateSpec, error) {
+	if w.kind == "pod" {
+		pod, err := w.clientset.CoreV1().Pods(w.namespace).Get(ctx, w.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", w, err)
+		}
+		return &apiv1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}, nil
+	}
+
+	template, _, err := w.controller(ctx)
+	return template, err
+}
+
+// Pods returns the pods of the workload. For a pod it is the pod itself.
+func (w *Workload) Pods(ctx context.Context) ([]apiv1.Pod, error) {
+	podsClient := w.clientset.CoreV1().Pods(w.namespace)
+
+	if w.kind == "pod" {
+		pod, err := podsClient.Get(ctx, w.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", w, err)
+		}
+		return []apiv1.Pod{*pod}, nil
+	}
+
+	selector, err := w.selector(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := podsClient.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods of %s: %w", w, err)
+	}
+	return pods.Items, nil
+}
+
+// ReadyPod returns a ready pod of the workload, the first one by name when
+// there are several.
+func (w *Workload) ReadyPod(ctx context.Context) (*apiv1.Pod, error) {
+	pods, err := w.Pods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ready *apiv1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil || !isReady(pod) {
+			continue
+		}
+		if ready == nil || pod.Name < ready.Name {
+			ready = pod
+		}
+	}
+
+	if ready == nil {
+		return nil, fmt.Errorf("no ready pods of %s found", w)
+	}
+	return ready, nil
+}
+
+func isReady(pod *apiv1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == apiv1.PodReady {
+			return condition.Status == apiv1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// NodeNames returns the names of the nodes the workload pods are scheduled on.
+func (w *Workload) NodeNames(ctx context.Context) ([]string, error) {
+	pods, err := w.Pods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodeNames []string
+	seen := map[string]bool{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || seen[pod.Spec.NodeName] {
+			continue
+		}
+		seen[pod.Spec.NodeName] = true
+		nodeNames = append(nodeNames, pod.Spec.NodeName)
+	}
+
+	if len(nodeNames) == 0 {
+		return nil, fmt.Errorf("no pods of %s are scheduled on a node", w)
+	}
+	return nodeNames, nil
+}
+
+func (w *Workload) selector(ctx context.Context) (string, error) {
+	selector, err := w.labelSelector(ctx)
+	if err != nil {
+		return "", err
+	}
+	return selector.String(), nil
+}
+
+func (w *Workload) labelSelector(ctx context.Context) (labels.Selector, error) {
+	_, labelSelector, err := w.controller(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector of %s: %w", w, err)
+	}
+	return selector, nil
+}
+
+// AdoptingSelector returns the selector of the controller which adopts
+// orphan pods with the labels of the workload pods, or nil when there is
+// none. Replicasets and daemonsets adopt all matching orphan pods, the
+// controller of a pod is looked up from its owner references. Deployments
+// adopt pods only through replicasets selecting the pod template hash label,
+// and statefulsets only pods named after them, so they are not considered.
+func (w *Workload) AdoptingSelector(ctx context.Context) (labels.Selector, error) {
+	controller := w
+	if w.kind == "pod" {
+		pod, err := w.clientset.CoreV1().Pods(w.namespace).Get(ctx, w.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", w, err)
+		}
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil {
+			return nil, nil
+		}
+		controller = &Workload{
+			clientset: w.clientset,
+			logger:    w.logger,
+			kind:      strings.ToLower(owner.Kind),
+			namespace: w.namespace,
+			name:      owner.Name,
+		}
+	}
+
+	if controller.kind != "replicaset" && controller.kind != "daemonset" {
+		return nil, nil
+	}
+	return controller.labelSelector(ctx)
+}
+
+// controller returns the pod template and the pod selector of the workload
+// controller.
+func (w *Workload) controller(ctx context.Context) (*apiv1.PodTemplateSpec, *metav1.LabelSelector, error) {
+	appsClient := w.clientset.AppsV1()
+
+	switch w.kind {
+	case "deployment":
+		deployment, err := appsClient.Deployments(w.namespace).Get(ctx, w.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get %s: %w", w, err)
+		}
+		return &deployment.Spec.Template, deployment.Spec.Selector, nil
+	case "statefulset":
+		statefulSet, err := appsClient.StatefulSets(w.namespace).Get(ctx, w.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get %s: %w", w, err)
+		}
+		return &statefulSet.Spec.Template, statefulSet.Spec.Selector, nil
+	case "daemonset":
+		daemonSet, err := appsClient.DaemonSets(w.namespace).Get(ctx, w.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get %s: %w", w, err)
+		}
+		return &daemonSet.Spec.Template, daemonSet.Spec.Selector, nil
+	case "replicaset":
+		replicaSet, err := appsClient.ReplicaSets(w.namespace).Get(ctx, w.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get %s: %w", w, err)
+		}
+		return &replicaSet.Spec.Template, replicaSet.Spec.Selector, nil
+	}
+
+	return nil, nil, fmt.Errorf("unsupported workload kind \"%s\"", w.kind)
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/plugin"
+	"github.com/spf13/cobra"
+)
+
+func gcCmd(config Config) *cobra.Command {
+	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	opts := &plugin.GCOpts{}
+	verbose := false
+
+	pluginName := config.PluginKind.String()
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Deletes expired " + pluginName + " pods in all namespaces",
+		Example: `# List the ` + pluginName + ` pods which would be deleted.
+kubectl ` + pluginName + ` gc --dry-run
+
+# Delete all ` + pluginName + ` pods in namespace foo.
+kubectl ` + pluginName + ` gc --all -n foo`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !verbose {
+				logger.SetOutput(io.Discard)
+			}
+			return plugin.RunGC(cmd.Context(), config.PluginKind, logger, opts, os.Stdout)
+		},
+	}
+
+	// The root command name is "kubectl", so the usage line is spelled out.
+	cmd.SetUsageTemplate(strings.Replace(cmd.UsageTemplate(), "{{.UseLine}}", "kubectl "+pluginName+" gc [flags]", 1))
+
+	cmd.Flags().StringVar(&opts.Kubeconfig, "kubeconfig", opts.Kubeconfig, "path to kubeconfig file")
+	cmd.Flags().StringVar(&opts.Context, "context", opts.Context, "the name of the kubeconfig context to use")
+	cmd.Flags().StringVarP(&opts.Namespace, "namespace", "n", opts.Namespace, "namespace in which "+pluginName+" pods are deleted, all namespaces when empty")
+	cmd.Flags().BoolVar(&opts.All, "all", opts.All, "delete all "+pluginName+" pods, not only the expired ones")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", opts.DryRun, "only list the "+pluginName+" pods which would be deleted")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", verbose, "explain what is being done")
+
+	return cmd
+}
@@ -1,15 +1,31 @@
 package cli
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"slices"
+	"syscall"
+	"time"
 
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
 	"github.com/michal-kopczynski/kubectl-curl/pkg/plugin"
 	"github.com/spf13/cobra"
 )
 
+// ExitCodePluginError is the exit code reserved for failures of the plugin
+// itself (Kubernetes API, transport or usage errors), as opposed to exit codes
+// propagated from the remote curl/grpcurl process.
+const ExitCodePluginError = 125
+
+// ExitCodeInterrupted is the exit code used when the plugin is interrupted by
+// SIGINT or SIGTERM, following the shell convention for SIGINT.
+const ExitCodeInterrupted = 130
+
 type Config struct {
 	PluginKind     plugin.PluginKind
 	Version        string
@@ -21,44 +37,79 @@ type Config struct {
 func RootCmd(config Config) *cobra.Command {
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 	opts := &plugin.Opts{
-		Kubeconfig: "",
-		Image:      config.DefaultImage,
-		Namespace:  "default",
-		PodName:    config.DefaultPodName,
-		Cleanup:    false,
-		Verbose:    false,
-		Timeout:    30,
+		Kubeconfig:      "",
+		Image:           config.DefaultImage,
+		Namespace:       "default",
+		PodName:         config.DefaultPodName,
+		Cleanup:         false,
+		Verbose:         false,
+		Timeout:         30,
+		SecurityProfile: plugin.ProfileRestricted,
+		Mode:            plugin.ModeExec,
+		KeepAlive:       plugin.KeepAliveAuto,
+		TTL:             24 * time.Hour,
+		Version:         config.Version,
+		DefaultImage:    config.DefaultImage,
 	}
 
 	pluginName := config.PluginKind.String()
 	cmd := &cobra.Command{
 		Use: `kubectl ` + pluginName + ` [` + pluginName + ` options]
   kubectl ` + pluginName + ` [plugin flags] -- [` + pluginName + ` options]`,
-		Short:        "Executes a " + pluginName + " command from a dedicated Kubernetes pod",
-		Example:      config.ExampleUsage,
-		SilenceUsage: true,
-		Version:      "kubect-" + config.PluginKind.String() + " version: " + config.Version,
+		Short:         "Executes a " + pluginName + " command from a dedicated Kubernetes pod",
+		Example:       config.ExampleUsage,
+		Args:          cobra.ArbitraryArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Version:       "kubect-" + config.PluginKind.String() + " version: " + config.Version,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if !opts.Verbose {
 				logger.SetOutput(io.Discard)
 			}
-			return plugin.RunPlugin(config.PluginKind, logger, opts, args)
+			return plugin.RunPlugin(cmd.Context(), config.PluginKind, logger, opts, args)
 		},
 	}
 
 	cmd.SetVersionTemplate(`{{printf "%s\n" .Version}}`)
 
 	cmd.DisableFlagsInUseLine = true
+	cmd.CompletionOptions.DisableDefaultCmd = true
+
+	// The gc subcommand is only registered when requested, so that curl
+	// arguments are never mistaken for it.
+	if (len(os.Args) > 1 && os.Args[1] == "gc") || slices.Contains(os.Args, "--help") {
+		cmd.AddCommand(gcCmd(config))
+	}
 
 	if slices.Contains(os.Args, "--") || slices.Contains(os.Args, "--help") || slices.Contains(os.Args, "--version") {
 		cmd.Flags().StringVar(&opts.Kubeconfig, "kubeconfig", opts.Kubeconfig, "path to kubeconfig file")
 		cmd.Flags().StringVar(&opts.Context, "context", opts.Context, "the name of the kubeconfig context to use")
 		cmd.Flags().StringVarP(&opts.Image, "image", "i", opts.Image, "docker image with "+pluginName+" tool")
+		cmd.Flags().StringArrayVar(&opts.ImagePullSecrets, "image-pull-secret", opts.ImagePullSecrets, "name of a secret used to pull the image of "+pluginName+" pod, can be repeated")
+		cmd.Flags().StringVar(&opts.ImagePullPolicy, "image-pull-policy", opts.ImagePullPolicy, "image pull policy of "+pluginName+" pod, one of: Always, IfNotPresent, Never")
+		cmd.Flags().StringVar(&opts.RegistryMirror, "registry-mirror", opts.RegistryMirror, "registry, optionally with a path prefix, from which the default image is pulled instead of Docker Hub")
 		cmd.Flags().StringVarP(&opts.Namespace, "namespace", "n", opts.Namespace, "namespace in which "+pluginName+" pod will be created")
 		cmd.Flags().StringVar(&opts.PodName, "name", opts.PodName, pluginName+" pod name")
 		cmd.Flags().BoolVarP(&opts.Cleanup, "cleanup", "c", opts.Cleanup, "delete "+pluginName+" pod at the end")
+		cmd.Flags().BoolVar(&opts.Wait, "wait", opts.Wait, "wait until the deleted "+pluginName+" pod is gone")
+		cmd.Flags().BoolVar(&opts.Unique, "unique", opts.Unique, "create a "+pluginName+" pod with a unique name generated from --name for this invocation only and delete it at the end")
 		cmd.Flags().BoolVarP(&opts.Verbose, "verbose", "v", opts.Verbose, "explain what is being done")
 		cmd.Flags().IntVarP(&opts.Timeout, "timeout", "t", opts.Timeout, "the timeout of plugin operations in seconds")
+		cmd.Flags().StringVar(&opts.Mode, "mode", opts.Mode, "exec to execute "+pluginName+" in a running pod, run to run it as the command of a one-shot pod without the pods/exec permission")
+		cmd.Flags().StringVar(&opts.KeepAlive, "keep-alive", opts.KeepAlive, "how "+pluginName+" pod is kept running: sleep runs sleep of the image, inject runs sleep of busybox injected by an init container for images without it, auto falls back to inject when the image has no sleep")
+		cmd.Flags().StringVar(&opts.SecurityProfile, "security-profile", opts.SecurityProfile, "security profile of "+pluginName+" pod, one of: restricted, baseline, privileged")
+		cmd.Flags().StringVar(&opts.Node, "node", opts.Node, "name of the node on which "+pluginName+" pod will be run")
+		cmd.Flags().StringArrayVar(&opts.NodeSelector, "node-selector", opts.NodeSelector, "key=value label of the nodes on which "+pluginName+" pod can be run, can be repeated")
+		cmd.Flags().StringArrayVar(&opts.Tolerations, "toleration", opts.Tolerations, "key[=value][:effect] taint tolerated by "+pluginName+" pod, \"*\" tolerates all taints, can be repeated")
+		cmd.Flags().StringVar(&opts.SameNodeAs, "same-node-as", opts.SameNodeAs, "[namespace/]kind/name of a pod, deployment, statefulset, daemonset or replicaset on whose nodes "+pluginName+" pod will be run")
+		cmd.Flags().StringVar(&opts.OtherNodeThan, "other-node-than", opts.OtherNodeThan, "[namespace/]kind/name of a pod, deployment, statefulset, daemonset or replicaset on whose nodes "+pluginName+" pod will not be run")
+		cmd.Flags().StringVar(&opts.Zone, "zone", opts.Zone, "zone in which "+pluginName+" pod will be run")
+		cmd.Flags().BoolVar(&opts.HostNetwork, "host-network", opts.HostNetwork, "run "+pluginName+" pod in the network namespace of its node, requires a namespace with the privileged Pod Security level")
+		cmd.Flags().DurationVar(&opts.TTL, "ttl", opts.TTL, "lifetime of "+pluginName+" pod after which it is terminated and can be deleted with \"kubectl "+pluginName+" gc\", 0 for no limit")
+		cmd.Flags().StringVar(&opts.Target, "target", opts.Target, "pod/name[:container] of an existing pod into which "+pluginName+" is injected as an ephemeral container sharing its network namespace")
+		cmd.Flags().StringVar(&opts.ExecIn, "exec-in", opts.ExecIn, "[namespace/]kind/name[:container] of a pod, deployment, statefulset, daemonset or replicaset in whose ready pod the "+pluginName+" binary of the container is executed without creating a plugin pod")
+		cmd.Flags().StringVar(&opts.AsWorkload, "as-workload", opts.AsWorkload, "[namespace/]kind/name of a pod, deployment, statefulset, daemonset or replicaset whose namespace, labels, service account and service mesh annotations "+pluginName+" pod will use, except a selector label of a replicaset or daemonset which would adopt it")
+		cmd.Flags().StringVar(&opts.Overrides, "overrides", opts.Overrides, "inline JSON/YAML or @file with overrides of the generated pod, applied as a strategic merge patch")
 	} else {
 		cmd.DisableFlagParsing = true
 	}
@@ -67,8 +118,39 @@ func RootCmd(config Config) *cobra.Command {
 }
 
 func InitAndExecute(config Config) error {
-	if err := RootCmd(config).Execute(); err != nil {
+	// SIGINT and SIGTERM cancel the plugin operations, which then clean up.
+	// Another signal received meanwhile terminates the process immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := RootCmd(config).ExecuteContext(ctx); err != nil {
+		// The remote command reports its own failures, other errors and
+		// errors joined with its exit code are printed.
+		var exitErr *apis.ExitError
+		if !errors.As(err, &exitErr) || error(exitErr) != err {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
 		return err
 	}
 	return nil
 }
+
+// ExitCode maps an error returned by InitAndExecute to the process exit code.
+// Exit codes of the remote command are propagated as is.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *apis.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	if errors.Is(err, context.Canceled) {
+		return ExitCodeInterrupted
+	}
+	return ExitCodePluginError
+}
@@ -0,0 +1,243 @@
+package plugin
+
+import (
+	"os"
+	"strings"
+)
+
+// referencesStdin reports whether the curl/grpcurl arguments make the tool read
+// data from its standard input, i.e. "-d @-", "-F file=<-" or "-T -" for curl
+// and "-d @" for grpcurl. Only the values of the options reading data are
+// checked, so that headers or URLs ending with "@-" are not mistaken for them.
+func referencesStdin(kind PluginKind, args []string) bool {
+	switch kind {
+	case Curl:
+		for _, option := range parseCurlOptions(args) {
+			switch option.name {
+			case "data", "data-ascii", "data-binary", "json":
+				if option.value == "@-" {
+					return true
+				}
+			case "data-urlencode":
+				if strings.HasSuffix(option.value, "@-") {
+					return true
+				}
+			case "form":
+				_, value, _ := strings.Cut(option.value, "=")
+				value, _, _ = strings.Cut(value, ";")
+				if value == "@-" || value == "<-" {
+					return true
+				}
+			case "upload-file", "config":
+				if option.value == "-" {
+					return true
+				}
+			}
+		}
+	case Grpcurl:
+		for _, option := range parseGrpcurlOptions(args) {
+			if option.name == "d" && option.value == "@" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stdinIsPipe reports whether the plugin standard input is a pipe or a
+// redirected file rather than a terminal.
+func stdinIsPipe() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice == 0
+}
+
+// curlShortOptions maps the curl short options which take a value to their
+// long names.
+var curlShortOptions = map[byte]string{
+	'A': "user-agent",
+	'b': "cookie",
+	'c': "cookie-jar",
+	'C': "continue-at",
+	'd': "data",
+	'D': "dump-header",
+	'e': "referer",
+	'E': "cert",
+	'F': "form",
+	'h': "help",
+	'H': "header",
+	'K': "config",
+	'm': "max-time",
+	'o': "output",
+	'P': "ftp-port",
+	'Q': "quote",
+	'r': "range",
+	't': "telnet-option",
+	'T': "upload-file",
+	'u': "user",
+	'U': "proxy-user",
+	'w': "write-out",
+	'x': "proxy",
+	'X': "request",
+	'y': "speed-time",
+	'Y': "speed-limit",
+	'z': "time-cond",
+}
+
+// curlLongOptions lists the curl long options which take a value and are
+// either inspected by the plugin or commonly used with values that could be
+// mistaken for options.
+var curlLongOptions = map[string]bool{
+	"cacert":          true,
+	"capath":          true,
+	"cert":            true,
+	"connect-timeout": true,
+	"connect-to":      true,
+	"crlfile":         true,
+	"data-ascii":      true,
+	"data-binary":     true,
+	"data-raw":        true,
+	"data-urlencode":  true,
+	"etag-save":       true,
+	"form-string":     true,
+	"json":            true,
+	"key":             true,
+	"netrc-file":      true,
+	"output-dir":      true,
+	"pinnedpubkey":    true,
+	"proxy-cacert":    true,
+	"proxy-cert":      true,
+	"proxy-header":    true,
+	"proxy-key":       true,
+	"resolve":         true,
+	"trace":           true,
+	"trace-ascii":     true,
+	"url":             true,
+}
+
+// curlShortFlags maps the curl short options without a value inspected by
+// the plugin to their long names.
+var curlShortFlags = map[byte]string{
+	'J': "remote-header-name",
+	'O': "remote-name",
+}
+
+// toolOption is an occurrence of a curl/grpcurl option. The value of an
+// option which takes one is stored in args[index], preceded by prefix when it
+// is attached to the option, i.e. "-d@file". Options without a value have
+// index -1.
+type toolOption struct {
+	name   string
+	index  int
+	prefix string
+	value  string
+}
+
+// curlTakesValue reports whether the curl long option name takes a value.
+func curlTakesValue(name string) bool {
+	if curlLongOptions[name] {
+		return true
+	}
+	for _, long := range curlShortOptions {
+		if long == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCurlOptions returns the curl options found in args. Short options
+// without a value which are not inspected by the plugin and positional
+// arguments are skipped.
+func parseCurlOptions(args []string) []toolOption {
+	var options []toolOption
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			name := strings.TrimPrefix(arg, "--")
+			if !curlTakesValue(name) {
+				options = append(options, toolOption{name: name, index: -1})
+				continue
+			}
+			if i+1 < len(args) {
+				i++
+				options = append(options, toolOption{name: name, index: i, value: args[i]})
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for j := 1; j < len(arg); j++ {
+				if name, ok := curlShortFlags[arg[j]]; ok {
+					options = append(options, toolOption{name: name, index: -1})
+					continue
+				}
+				name, ok := curlShortOptions[arg[j]]
+				if !ok {
+					continue
+				}
+				if j+1 < len(arg) {
+					options = append(options, toolOption{name: name, index: i, prefix: arg[:j+1], value: arg[j+1:]})
+				} else if i+1 < len(args) {
+					i++
+					options = append(options, toolOption{name: name, index: i, value: args[i]})
+				}
+				break
+			}
+		}
+	}
+	return options
+}
+
+// grpcurlValueFlags lists the grpcurl flags which take a value.
+var grpcurlValueFlags = map[string]bool{
+	"H":                           true,
+	"alts-handshaker-service":     true,
+	"alts-target-service-account": true,
+	"authority":                   true,
+	"cacert":                      true,
+	"cert":                        true,
+	"connect-timeout":             true,
+	"d":                           true,
+	"format":                      true,
+	"import-path":                 true,
+	"keepalive-time":              true,
+	"key":                         true,
+	"max-msg-sz":                  true,
+	"max-time":                    true,
+	"proto":                       true,
+	"protoset":                    true,
+	"protoset-out":                true,
+	"reflect-header":              true,
+	"rpc-header":                  true,
+	"servername":                  true,
+	"user-agent":                  true,
+}
+
+// parseGrpcurlOptions returns the grpcurl flags found in args. Like the Go
+// flag package used by grpcurl, parsing stops at the first positional
+// argument or at "--". Values given as "-flag=value" have the "-flag=" prefix.
+func parseGrpcurlOptions(args []string) []toolOption {
+	var options []toolOption
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || len(arg) == 1 {
+			break
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if name, value, found := strings.Cut(name, "="); found {
+			options = append(options, toolOption{name: name, index: i, prefix: arg[:len(arg)-len(value)], value: value})
+			continue
+		}
+		if !grpcurlValueFlags[name] {
+			options = append(options, toolOption{name: name, index: -1})
+			continue
+		}
+		if i+1 < len(args) {
+			i++
+			options = append(options, toolOption{name: name, index: i, value: args[i]})
+		}
+	}
+	return options
+}
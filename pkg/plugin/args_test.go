@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCurlOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected []toolOption
+	}{
+		{
+			name:     "separate value",
+			args:     []string{"-d", "@body.json", "http://example.com"},
+			expected: []toolOption{{name: "data", index: 1, value: "@body.json"}},
+		},
+		{
+			name:     "attached value",
+			args:     []string{"-d@body.json", "http://example.com"},
+			expected: []toolOption{{name: "data", index: 0, prefix: "-d", value: "@body.json"}},
+		},
+		{
+			name:     "short option cluster ending with an option taking a value",
+			args:     []string{"-sSLo", "out.bin", "http://example.com"},
+			expected: []toolOption{{name: "output", index: 1, value: "out.bin"}},
+		},
+		{
+			name:     "short option cluster with an attached value",
+			args:     []string{"-sXPOST", "http://example.com"},
+			expected: []toolOption{{name: "request", index: 0, prefix: "-sX", value: "POST"}},
+		},
+		{
+			name: "short flags inspected by the plugin",
+			args: []string{"-sOJ", "http://example.com"},
+			expected: []toolOption{
+				{name: "remote-name", index: -1},
+				{name: "remote-header-name", index: -1},
+			},
+		},
+		{
+			name:     "form with content type",
+			args:     []string{"-F", "x=@image.png;type=image/png", "http://example.com"},
+			expected: []toolOption{{name: "form", index: 1, value: "x=@image.png;type=image/png"}},
+		},
+		{
+			name: "long options",
+			args: []string{"--compressed", "--cert", "client.pem:secret", "http://example.com"},
+			expected: []toolOption{
+				{name: "compressed", index: -1},
+				{name: "cert", index: 2, value: "client.pem:secret"},
+			},
+		},
+		{
+			name:     "value looking like an option",
+			args:     []string{"-H", "-X: y", "--data-raw", "-d", "http://example.com"},
+			expected: []toolOption{{name: "header", index: 1, value: "-X: y"}, {name: "data-raw", index: 3, value: "-d"}},
+		},
+		{
+			name:     "missing value",
+			args:     []string{"http://example.com", "-o"},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := parseCurlOptions(tt.args)
+			if !reflect.DeepEqual(options, tt.expected) {
+				t.Errorf("parseCurlOptions(%q) = %+v, expected %+v", tt.args, options, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReferencesStdin(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     PluginKind
+		args     []string
+		expected bool
+	}{
+		{name: "curl data", kind: Curl, args: []string{"-d", "@-", "http://example.com"}, expected: true},
+		{name: "curl attached data", kind: Curl, args: []string{"-sd@-", "http://example.com"}, expected: true},
+		{name: "curl json", kind: Curl, args: []string{"--json", "@-", "http://example.com"}, expected: true},
+		{name: "curl data urlencode", kind: Curl, args: []string{"--data-urlencode", "q@-", "http://example.com"}, expected: true},
+		{name: "curl form file", kind: Curl, args: []string{"-F", "x=@-;type=text/plain", "http://example.com"}, expected: true},
+		{name: "curl form content", kind: Curl, args: []string{"-F", "x=<-", "http://example.com"}, expected: true},
+		{name: "curl upload", kind: Curl, args: []string{"-T", "-", "http://example.com/"}, expected: true},
+		{name: "curl config", kind: Curl, args: []string{"-K-"}, expected: true},
+		{name: "curl data from file", kind: Curl, args: []string{"-d", "@body.json", "http://example.com"}, expected: false},
+		{name: "curl raw data", kind: Curl, args: []string{"--data-raw", "@-", "http://example.com"}, expected: false},
+		{name: "curl header ending with @-", kind: Curl, args: []string{"-H", "X-Mail: user@-", "http://example.com"}, expected: false},
+		{name: "curl url ending with @-", kind: Curl, args: []string{"http://example.com/user@-"}, expected: false},
+		{name: "curl output to stdout", kind: Curl, args: []string{"-o", "-", "http://example.com"}, expected: false},
+		{name: "grpcurl data", kind: Grpcurl, args: []string{"-d", "@", "localhost:50051", "Service/Method"}, expected: true},
+		{name: "grpcurl attached data", kind: Grpcurl, args: []string{"-d=@", "localhost:50051", "Service/Method"}, expected: true},
+		{name: "grpcurl inline data", kind: Grpcurl, args: []string{"-d", "{}", "localhost:50051", "Service/Method"}, expected: false},
+		{name: "grpcurl header", kind: Grpcurl, args: []string{"-H", "@", "localhost:50051", "Service/Method"}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := referencesStdin(tt.kind, tt.args); result != tt.expected {
+				t.Errorf("referencesStdin(%s, %q) = %t, expected %t", tt.kind, tt.args, result, tt.expected)
+			}
+		})
+	}
+}
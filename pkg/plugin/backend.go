@@ -0,0 +1,357 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// backend provides the container in which the curl/grpcurl command is
+// executed.
+type backend interface {
+	// Prepare makes the container ready for executing commands.
+	Prepare(ctx context.Context) (*apis.Container, error)
+	// Cleanup releases the resources created by Prepare.
+	Cleanup(ctx context.Context) error
+}
+
+// checkNoPodOptions fails when options of the plugin pod are combined with
+// the flag selecting a backend which does not create a plugin pod. Image pull
+// secrets can only be set on pods.
+func checkNoPodOptions(flag string, opts *Opts) error {
+	if len(opts.ImagePullSecrets) > 0 {
+		return fmt.Errorf("%s can not be combined with --image-pull-secret, the image pull secrets of the existing pod are used", flag)
+	}
+	if opts.AsWorkload != "" || opts.Overrides != "" || opts.Node != "" || len(opts.NodeSelector) > 0 || len(opts.Tolerations) > 0 ||
+		opts.SameNodeAs != "" || opts.OtherNodeThan != "" || opts.Zone != "" || opts.HostNetwork || opts.Unique || opts.KeepAlive != KeepAliveAuto {
+		return fmt.Errorf("%s can not be combined with options of the plugin pod: --as-workload, --overrides, --node, --node-selector, --toleration, --same-node-as, --other-node-than, --zone, --host-network, --unique and --keep-alive", flag)
+	}
+	return nil
+}
+
+// podBackend executes commands in a dedicated plugin pod, which is created
+// or reused and deleted at the end with --cleanup.
+type podBackend struct {
+	pod     *apis.Pod
+	logger  *log.Logger
+	kind    PluginKind
+	opts    *Opts
+	timeout time.Duration
+	// keepAlive is switched to the injected busybox when the image has no
+	// sleep.
+	keepAlive *keepAlive
+	// holder identifies the invocation in the in use marks of a shared pod.
+	holder   string
+	acquired bool
+	// created is set when the unique pod of the invocation was created.
+	created bool
+}
+
+func newPodBackend(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, clientConfig clientcmd.ClientConfig, logger *log.Logger, kind PluginKind, opts *Opts, timeout time.Duration) (*podBackend, error) {
+	if err := checkKeepAlive(opts.KeepAlive); err != nil {
+		return nil, err
+	}
+	keepAlive := &keepAlive{
+		image:  KeepAliveImage,
+		inject: opts.KeepAlive == KeepAliveInject,
+	}
+	if opts.RegistryMirror != "" {
+		keepAlive.image = mirrorImage(opts.RegistryMirror, keepAlive.image)
+	}
+
+	namespace, permissions, err := podOptionPermissions(clientset, logger, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPermissions(ctx, clientset, logger, namespace, "--mode="+ModeExec, opts.Unique, permissions); err != nil {
+		return nil, err
+	}
+
+	pod, err := newPluginPod(ctx, clientset, config, clientConfig, logger, kind, opts, []string{"sleep", "infinity"}, "", keepAlive)
+	if err != nil {
+		return nil, err
+	}
+
+	return &podBackend{
+		pod:       pod,
+		logger:    logger,
+		kind:      kind,
+		opts:      opts,
+		timeout:   timeout,
+		keepAlive: keepAlive,
+		holder:    rand.String(8),
+	}, nil
+}
+
+// newPluginPod returns the plugin pod running command, generated from the
+// plugin options. The pod restart policy is set unless empty and the
+// keep-alive mutation is applied unless keepAlive is nil.
+func newPluginPod(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, clientConfig clientcmd.ClientConfig, logger *log.Logger, kind PluginKind, opts *Opts, command []string, restartPolicy apiv1.RestartPolicy, keepAlive *keepAlive) (*apis.Pod, error) {
+	var workload *apis.Workload
+	if opts.AsWorkload != "" {
+		var err error
+		workload, err = apis.NewWorkload(clientset, logger, opts.Namespace, opts.AsWorkload)
+		if err != nil {
+			return nil, err
+		}
+		opts.Namespace = workload.Namespace()
+	}
+
+	pod := apis.NewPod(
+		clientset,
+		config,
+		logger,
+		opts.Image,
+		opts.Namespace,
+		opts.PodName,
+		command,
+		0)
+
+	if opts.Unique {
+		pod.GenerateName()
+	}
+	pod.AddMutation(ownershipMutation(kind, currentUser(ctx, clientset, clientConfig, opts.Context), opts.Version))
+	pod.AddMutation(expiryMutation(opts.TTL))
+	pod.IgnoreInHash(ExpiresAtAnnotation)
+	if keepAlive != nil {
+		pod.AddMutation(keepAlive.mutation())
+	}
+
+	pod.AddMutation(imagePullMutation(opts.ImagePullSecrets, opts.ImagePullPolicy))
+
+	mutation, err := securityMutation(opts.SecurityProfile)
+	if err != nil {
+		return nil, err
+	}
+	pod.AddMutation(mutation)
+	if opts.HostNetwork {
+		pod.AddMutation(hostNetworkMutation)
+	}
+	if err := checkPodSecurity(ctx, clientset, logger, opts.Namespace, opts.SecurityProfile, opts.HostNetwork); err != nil {
+		return nil, err
+	}
+
+	mutation, err = schedulingMutation(ctx, clientset, logger, opts)
+	if err != nil {
+		return nil, err
+	}
+	pod.AddMutation(mutation)
+
+	if workload != nil {
+		mutation, err = workloadIdentityMutation(ctx, clientset, logger, workload)
+		if err != nil {
+			return nil, err
+		}
+		pod.AddMutation(mutation)
+	}
+
+	if restartPolicy != "" {
+		pod.AddMutation(func(pod *apiv1.Pod) error {
+			pod.Spec.RestartPolicy = restartPolicy
+			return nil
+		})
+	}
+
+	// Overrides are applied last, on top of everything generated by the plugin.
+	if opts.Overrides != "" {
+		mutation, err = overridesMutation(logger, opts.Overrides)
+		if err != nil {
+			return nil, err
+		}
+		pod.AddMutation(mutation)
+	}
+
+	return pod, nil
+}
+
+// Prepare creates or reuses the plugin pod and checks that the tool binary
+// can be executed in it. With --keep-alive=auto, a pod whose image has no
+// sleep is recreated with the injected busybox.
+func (b *podBackend) Prepare(ctx context.Context) (*apis.Container, error) {
+	if b.opts.Unique {
+		if err := b.pod.Create(ctx); err != nil {
+			return nil, fmt.Errorf("error creating \"%s\" pod: %w", b.opts.PodName, err)
+		}
+		b.created = true
+	} else {
+		if err := b.reuseKeepAlive(ctx); err != nil {
+			return nil, err
+		}
+		if err := b.reconcileShared(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	err := b.pod.WaitForReady(ctx, b.timeout)
+	if errors.Is(err, apis.ErrCommandNotFound) && b.opts.KeepAlive == KeepAliveAuto && !b.keepAlive.inject {
+		b.logger.Printf("Image %s has no sleep, recreating pod \"%s\" with sleep injected from %s.\n", b.opts.Image, b.pod.Name(), b.keepAlive.image)
+		b.keepAlive.inject = true
+		if err := b.recreate(ctx); err != nil {
+			return nil, err
+		}
+		err = b.pod.WaitForReady(ctx, b.timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error waiting for \"%s\" readiness: %w", b.pod.Name(), err)
+	}
+
+	container := b.pod.Container()
+	if b.keepAlive.inject {
+		container.UseUtils(injectedUtils)
+	}
+	if err := checkBinary(ctx, container, b.kind, b.timeout, "use --image with an image containing it"); err != nil {
+		return nil, err
+	}
+
+	// The mark is renewed so that it covers the operations running the
+	// command, however long the pod took to become ready.
+	if b.acquired {
+		if _, err := acquirePod(ctx, b.pod, b.holder, b.timeout); err != nil {
+			return nil, fmt.Errorf("error renewing the in use mark of \"%s\" pod: %w", b.pod.Name(), err)
+		}
+	}
+	return container, nil
+}
+
+// reuseKeepAlive switches to the injected busybox with --keep-alive=auto
+// when the existing shared pod already uses it, so that it is not recreated
+// with sleep of the image on every invocation.
+func (b *podBackend) reuseKeepAlive(ctx context.Context) error {
+	if b.opts.KeepAlive != KeepAliveAuto {
+		return nil
+	}
+	existing, err := b.pod.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("error checking if \"%s\" exists: %w", b.pod.Name(), err)
+	}
+	if existing != nil && existing.Annotations[KeepAliveAnnotation] == KeepAliveInject {
+		b.keepAlive.inject = true
+	}
+	return nil
+}
+
+// recreate replaces the plugin pod created or acquired by Prepare with one
+// generated with the current options.
+func (b *podBackend) recreate(ctx context.Context) error {
+	if !b.opts.Unique {
+		if err := releasePod(ctx, b.pod, b.holder); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error releasing \"%s\" pod: %w", b.pod.Name(), err)
+		}
+		b.acquired = false
+		return b.reconcileShared(ctx)
+	}
+
+	if err := b.pod.Delete(ctx); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting \"%s\" pod: %w", b.pod.Name(), err)
+	}
+	b.created = false
+	if err := b.pod.WaitForDeletion(ctx, b.timeout); err != nil {
+		return fmt.Errorf("error waiting for \"%s\" deletion: %w", b.pod.Name(), err)
+	}
+	if err := b.pod.Create(ctx); err != nil {
+		return fmt.Errorf("error creating \"%s\" pod: %w", b.pod.Name(), err)
+	}
+	b.created = true
+	return nil
+}
+
+// reconcileShared reconciles the shared plugin pod and marks it as in use,
+// retrying when the pod is deleted concurrently by another invocation.
+func (b *podBackend) reconcileShared(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		if err := reconcilePod(ctx, b.pod, b.logger, b.kind, b.timeout); err != nil {
+			return err
+		}
+
+		existing, err := acquirePod(ctx, b.pod, b.holder, b.timeout)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error marking \"%s\" pod as in use: %w", b.pod.Name(), err)
+		}
+		if err == nil && existing.DeletionTimestamp == nil {
+			b.acquired = true
+			return nil
+		}
+		if attempt == maxAttempts {
+			return fmt.Errorf("pod \"%s\" was repeatedly deleted by concurrent invocations", b.pod.Name())
+		}
+		b.logger.Printf("Pod \"%s\" was deleted concurrently, recreating it.\n", b.pod.Name())
+	}
+}
+
+// Cleanup releases the in use mark of the shared plugin pod and, with
+// --cleanup, deletes it unless other invocations still use it. Unique pods
+// are always deleted. Pods which this invocation neither created nor marked
+// as in use are left untouched. With --wait, Cleanup returns once the pod is
+// gone.
+func (b *podBackend) Cleanup(ctx context.Context) error {
+	if b.opts.Unique {
+		if !b.created {
+			return nil
+		}
+		if err := b.pod.Delete(ctx); err != nil {
+			return fmt.Errorf("error deleting \"%s\" pod: %w", b.pod.Name(), err)
+		}
+		return b.waitForDeletion(ctx)
+	}
+
+	if !b.acquired {
+		return nil
+	}
+	if err := releasePod(ctx, b.pod, b.holder); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error releasing \"%s\" pod: %w", b.pod.Name(), err)
+	}
+	b.acquired = false
+
+	if !b.opts.Cleanup {
+		return nil
+	}
+
+	// The pod is deleted only when unchanged since it was checked for other
+	// users, so that an invocation marking it as in use meanwhile is noticed.
+	for attempt := 1; ; attempt++ {
+		existing, err := b.pod.Get(ctx)
+		if err != nil {
+			return fmt.Errorf("error checking if \"%s\" exists: %w", b.pod.Name(), err)
+		}
+		if existing == nil {
+			return nil
+		}
+		if users := podUsers(existing, b.holder, time.Now()); users > 0 {
+			b.logger.Printf("Not deleting pod \"%s\", it is in use by %d other invocations.\n", b.pod.Name(), users)
+			return nil
+		}
+
+		err = b.pod.DeleteUnchanged(ctx, existing.ResourceVersion)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err == nil {
+			return b.waitForDeletion(ctx)
+		}
+		if !apierrors.IsConflict(err) || attempt == maxAttempts {
+			return fmt.Errorf("error deleting \"%s\" pod: %w", b.pod.Name(), err)
+		}
+	}
+}
+
+// waitForDeletion waits until the deleted pod is gone when requested with
+// --wait.
+func (b *podBackend) waitForDeletion(ctx context.Context) error {
+	if !b.opts.Wait {
+		return nil
+	}
+	if err := b.pod.WaitForDeletion(ctx, b.timeout); err != nil {
+		return fmt.Errorf("error waiting for \"%s\" deletion: %w", b.pod.Name(), err)
+	}
+	return nil
+}
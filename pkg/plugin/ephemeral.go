@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// ephemeralLifetime is how long ephemeral containers keep running. They can
+// not be removed from the target pod, so they exit on their own afterwards.
+const ephemeralLifetime = time.Hour
+
+// ephemeralBackend executes commands in an ephemeral container injected
+// into an existing pod, sharing its network namespace. Ephemeral containers
+// can not have init containers nor mount new volumes, so unlike plugin pods
+// they can only be kept running with sleep of the tool image.
+type ephemeralBackend struct {
+	container *apis.EphemeralContainer
+	logger    *log.Logger
+	kind      PluginKind
+	image     string
+	timeout   time.Duration
+}
+
+func newEphemeralBackend(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, logger *log.Logger, kind PluginKind, opts *Opts, timeout time.Duration) (*ephemeralBackend, error) {
+	if err := checkNoPodOptions("--target", opts); err != nil {
+		return nil, err
+	}
+
+	podName, targetContainer, err := parsePodTarget(opts.Target)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPermissions(ctx, clientset, logger, opts.Namespace, "--target", false, nil); err != nil {
+		return nil, err
+	}
+
+	securityContext, err := ephemeralSecurityContext(opts.SecurityProfile)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPodSecurity(ctx, clientset, logger, opts.Namespace, opts.SecurityProfile, false); err != nil {
+		return nil, err
+	}
+
+	container := apis.NewEphemeralContainer(
+		clientset,
+		config,
+		logger,
+		opts.Image,
+		apiv1.PullPolicy(opts.ImagePullPolicy),
+		opts.Namespace,
+		podName,
+		"kubectl-"+kind.String()+"-"+rand.String(5),
+		targetContainer,
+		[]string{"sleep", fmt.Sprint(int(ephemeralLifetime.Seconds()))},
+		securityContext)
+
+	return &ephemeralBackend{
+		container: container,
+		logger:    logger,
+		kind:      kind,
+		image:     opts.Image,
+		timeout:   timeout,
+	}, nil
+}
+
+// parsePodTarget parses a "[pod/]name[:container]" reference to a pod and
+// optionally one of its containers.
+func parsePodTarget(ref string) (string, string, error) {
+	name, container, _ := strings.Cut(ref, ":")
+	if kind, podName, found := strings.Cut(name, "/"); found {
+		if kind != "pod" && kind != "pods" && kind != "po" {
+			return "", "", fmt.Errorf("invalid target \"%s\", must be a pod reference like pod/name[:container]", ref)
+		}
+		name = podName
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("invalid target \"%s\", must be a pod reference like pod/name[:container]", ref)
+	}
+	return name, container, nil
+}
+
+func (b *ephemeralBackend) Prepare(ctx context.Context) (*apis.Container, error) {
+	if err := b.container.Create(ctx); err != nil {
+		return nil, err
+	}
+
+	err := b.container.WaitForReady(ctx, b.timeout)
+	if errors.Is(err, apis.ErrCommandNotFound) {
+		return nil, fmt.Errorf("image %s has no sleep to keep the ephemeral container running, use --image with an image containing sleep or omit --target to run %s in a plugin pod: %w", b.image, b.kind, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	container := b.container.Container()
+	if err := checkBinary(ctx, container, b.kind, b.timeout, "use --image with an image containing it"); err != nil {
+		return nil, err
+	}
+	return container, nil
+}
+
+func (b *ephemeralBackend) Cleanup(ctx context.Context) error {
+	b.logger.Printf("Ephemeral containers can not be removed, %s exits after %s.\n", b.container.Container(), ephemeralLifetime)
+	return nil
+}
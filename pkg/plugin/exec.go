@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// defaultContainerAnnotation names the container kubectl exec uses when none
+// is given.
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// execBackend executes commands with the tool binary already present in a
+// container of an existing pod, without creating any resources.
+type execBackend struct {
+	clientset *kubernetes.Clientset
+	config    *rest.Config
+	logger    *log.Logger
+	kind      PluginKind
+	workload  *apis.Workload
+	container string
+	timeout   time.Duration
+}
+
+func newExecBackend(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, logger *log.Logger, kind PluginKind, opts *Opts, timeout time.Duration) (*execBackend, error) {
+	if err := checkNoPodOptions("--exec-in", opts); err != nil {
+		return nil, err
+	}
+	if opts.Target != "" {
+		return nil, fmt.Errorf("--exec-in can not be combined with --target")
+	}
+	if opts.ImagePullPolicy != "" {
+		return nil, fmt.Errorf("--exec-in can not be combined with --image-pull-policy, no image is pulled")
+	}
+
+	ref, container, _ := strings.Cut(opts.ExecIn, ":")
+	workload, err := apis.NewWorkload(clientset, logger, opts.Namespace, ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPermissions(ctx, clientset, logger, workload.Namespace(), "--exec-in", false, workloadPermissions(workload, workload.Namespace(), false)); err != nil {
+		return nil, err
+	}
+
+	return &execBackend{
+		clientset: clientset,
+		config:    config,
+		logger:    logger,
+		kind:      kind,
+		workload:  workload,
+		container: container,
+		timeout:   timeout,
+	}, nil
+}
+
+func (b *execBackend) Prepare(ctx context.Context) (*apis.Container, error) {
+	pod, err := b.workload.ReadyPod(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if b.workload.Kind() != "pod" {
+		b.logger.Printf("Using pod \"%s\" of %s.\n", pod.Name, b.workload)
+	}
+
+	name := b.container
+	if name == "" {
+		name = defaultContainer(pod)
+	} else if !apis.HasContainer(pod, name) {
+		return nil, fmt.Errorf("container \"%s\" not found in pod \"%s\"", name, pod.Name)
+	}
+
+	container := apis.NewContainer(b.clientset, b.config, b.logger, pod.Namespace, pod.Name, name)
+	if err := checkBinary(ctx, container, b.kind, b.timeout, "use --target to inject it in an ephemeral container or omit --exec-in to run it in a plugin pod"); err != nil {
+		return nil, err
+	}
+	return container, nil
+}
+
+// defaultContainer returns the container kubectl exec would use for pod.
+func defaultContainer(pod *apiv1.Pod) string {
+	if name, ok := pod.Annotations[defaultContainerAnnotation]; ok && apis.HasContainer(pod, name) {
+		return name
+	}
+	return pod.Spec.Containers[0].Name
+}
+
+// checkBinary verifies that the tool binary can be executed in container by
+// running its version command. hint is appended to the error when the binary
+// is not found.
+func checkBinary(ctx context.Context, container *apis.Container, kind PluginKind, timeout time.Duration, hint string) error {
+	command := []string{kind.String(), "--version"}
+	if kind == Grpcurl {
+		command = []string{kind.String(), "-version"}
+	}
+
+	var exitErr *apis.ExitError
+	err := container.ExecuteCommand(ctx, command, nil, io.Discard, io.Discard, timeout)
+	if err == nil {
+		return nil
+	}
+	if errors.As(err, &exitErr) || strings.Contains(err.Error(), "executable file not found") || strings.Contains(err.Error(), "no such file or directory") {
+		return binaryNotFound(kind, container.String(), hint)
+	}
+	return fmt.Errorf("error checking %s binary in %s: %w", kind, container, err)
+}
+
+// binaryNotFound returns the error reported when the tool binary is missing
+// in where, followed by hint.
+func binaryNotFound(kind PluginKind, where string, hint string) error {
+	return fmt.Errorf("%s binary not found on the PATH of %s, %s", kind, where, hint)
+}
+
+func (b *execBackend) Cleanup(ctx context.Context) error {
+	return nil
+}
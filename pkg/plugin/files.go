@@ -0,0 +1,340 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+// fileRef is a local file path referenced by the value of the option tool
+// argument. The argument args[index] is prefix + path + suffix. A reference
+// to a directory lists the files to upload from it, relative to path.
+type fileRef struct {
+	option string
+	index  int
+	prefix string
+	path   string
+	suffix string
+	files  []string
+}
+
+// inputFiles returns the existing local files referenced by the tool
+// arguments which have to be uploaded into the pod, together with the
+// arguments the references point into.
+func inputFiles(kind PluginKind, args []string) ([]string, []fileRef) {
+	switch kind {
+	case Curl:
+		return args, curlInputFiles(args)
+	case Grpcurl:
+		return grpcurlInputFiles(args)
+	}
+	return args, nil
+}
+
+// curlInputFiles returns the existing local files read by curl options, i.e.
+// "-d @body.json", "-F upload=@image.png;type=image/png", "-T file" or
+// "--cacert ca.pem".
+func curlInputFiles(args []string) []fileRef {
+	var refs []fileRef
+	for _, opt := range parseCurlOptions(args) {
+		var ref *fileRef
+		switch opt.name {
+		case "data", "data-ascii", "data-binary", "json", "header", "proxy-header":
+			if strings.HasPrefix(opt.value, "@") {
+				ref = &fileRef{prefix: "@", path: opt.value[1:]}
+			}
+		case "data-urlencode":
+			at := strings.Index(opt.value, "@")
+			if at != -1 && !strings.Contains(opt.value[:at], "=") {
+				ref = &fileRef{prefix: opt.value[:at+1], path: opt.value[at+1:]}
+			}
+		case "form":
+			eq := strings.Index(opt.value, "=")
+			if eq != -1 && eq+1 < len(opt.value) && strings.ContainsAny(opt.value[eq+1:eq+2], "@<") {
+				path, suffix, _ := strings.Cut(opt.value[eq+2:], ";")
+				if suffix != "" {
+					suffix = ";" + suffix
+				}
+				ref = &fileRef{prefix: opt.value[:eq+2], path: path, suffix: suffix}
+			}
+		case "cookie":
+			if !strings.Contains(opt.value, "=") {
+				ref = &fileRef{path: opt.value}
+			}
+		case "cert", "proxy-cert":
+			ref = &fileRef{path: opt.value}
+			if !isRegularFile(opt.value) {
+				if path, password, found := strings.Cut(opt.value, ":"); found {
+					ref = &fileRef{path: path, suffix: ":" + password}
+				}
+			}
+		case "upload-file", "config", "cacert", "crlfile", "key", "netrc-file", "proxy-cacert", "proxy-key":
+			ref = &fileRef{path: opt.value}
+		}
+
+		if ref == nil || ref.path == "-" || ref.path == "." || !isRegularFile(ref.path) {
+			continue
+		}
+		ref.option = opt.name
+		ref.index = opt.index
+		ref.prefix = opt.prefix + ref.prefix
+		refs = append(refs, *ref)
+	}
+	return refs
+}
+
+// protoImportRegexp matches import statements of .proto files.
+var protoImportRegexp = regexp.MustCompile(`(?m)^\s*import\s+(?:public\s+|weak\s+)?"([^"]+)"\s*;`)
+
+// grpcurlInputFiles returns the existing local files read by grpcurl flags:
+// the -proto files together with their transitive imports, uploaded as trees
+// under their -import-path directories, and the -protoset, -cacert, -cert and
+// -key files. Without any -import-path grpcurl resolves -proto files from
+// the current directory, so "-import-path ." is added to the returned
+// arguments in that case.
+func grpcurlInputFiles(args []string) ([]string, []fileRef) {
+	options := parseGrpcurlOptions(args)
+
+	var protos []string
+	hasImportPath := false
+	for _, opt := range options {
+		switch opt.name {
+		case "proto":
+			protos = append(protos, opt.value)
+		case "import-path":
+			hasImportPath = true
+		}
+	}
+	if len(protos) > 0 && !hasImportPath {
+		args = append([]string{"-import-path", "."}, args...)
+		options = parseGrpcurlOptions(args)
+	}
+
+	var refs []fileRef
+	var importPaths []*fileRef
+	for _, opt := range options {
+		switch opt.name {
+		case "import-path":
+			importPaths = append(importPaths, &fileRef{option: opt.name, index: opt.index, prefix: opt.prefix, path: opt.value})
+		case "protoset", "cacert", "cert", "key":
+			if isRegularFile(opt.value) {
+				refs = append(refs, fileRef{option: opt.name, index: opt.index, prefix: opt.prefix, path: opt.value})
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for len(protos) > 0 {
+		name := protos[0]
+		protos = protos[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		for _, importPath := range importPaths {
+			localPath := filepath.Join(importPath.path, filepath.FromSlash(name))
+			if !isRegularFile(localPath) {
+				continue
+			}
+			importPath.files = append(importPath.files, name)
+			content, err := os.ReadFile(localPath)
+			if err == nil {
+				for _, match := range protoImportRegexp.FindAllStringSubmatch(string(content), -1) {
+					protos = append(protos, match[1])
+				}
+			}
+			break
+		}
+	}
+
+	for _, importPath := range importPaths {
+		if len(importPath.files) > 0 {
+			refs = append(refs, *importPath)
+		}
+	}
+
+	return args, refs
+}
+
+func isRegularFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// uploadFiles copies the referenced local files into dir inside the container
+// and returns a copy of args with the references rewritten to the pod paths.
+func uploadFiles(ctx context.Context, container *apis.Container, logger *log.Logger, refs []fileRef, args []string, dir string, timeout time.Duration) ([]string, error) {
+	rewritten, files := planUploads(refs, args, dir)
+	logged := map[string]bool{}
+	for _, ref := range refs {
+		if !logged[ref.path] {
+			logged[ref.path] = true
+			logger.Printf("Uploading \"%s\" to the pod.\n", ref.path)
+		}
+	}
+
+	if err := container.CopyTo(ctx, files, dir, timeout); err != nil {
+		return nil, err
+	}
+
+	return rewritten, nil
+}
+
+// planUploads returns a copy of args with the references rewritten to paths
+// inside dir, and the files to upload by their names relative to dir. Every
+// referenced path is uploaded into its own numbered subdirectory under its
+// base name, which curl sends as the file name of "-F" uploads and appends to
+// "-T" URLs ending with "/".
+func planUploads(refs []fileRef, args []string, dir string) ([]string, map[string]string) {
+	rewritten := slices.Clone(args)
+	files := map[string]string{}
+	names := map[string]string{}
+	for _, ref := range refs {
+		name, ok := names[ref.path]
+		if !ok {
+			base := filepath.Base(ref.path)
+			if absPath, err := filepath.Abs(ref.path); err == nil {
+				base = filepath.Base(absPath)
+			}
+			name = path.Join(strconv.Itoa(len(names)), base)
+			names[ref.path] = name
+			if ref.files == nil {
+				files[name] = ref.path
+			}
+		}
+		for _, file := range ref.files {
+			files[path.Join(name, file)] = filepath.Join(ref.path, filepath.FromSlash(file))
+		}
+		rewritten[ref.index] = ref.prefix + path.Join(dir, name) + ref.suffix
+	}
+	return rewritten, files
+}
+
+// scratchRoot is the writable directory of the plugin pod under which the
+// files of plugin invocations are stored.
+const scratchRoot = "/tmp"
+
+// scratchDir returns a unique directory path inside the pod for the files
+// of a single plugin invocation.
+func scratchDir(kind PluginKind) string {
+	return path.Join(scratchRoot, "kubectl-"+kind.String()+"-"+rand.String(8))
+}
+
+// downloads describes the files written by curl inside the pod which have to
+// be copied back to the local machine.
+type downloads struct {
+	// refs are the explicit output files, i.e. "-o out.bin" or "-D headers.txt".
+	refs []fileRef
+	// outputDir is the local directory for files named by curl itself (-O, -J)
+	// and for relative -o paths.
+	outputDir string
+	// outputDirIndex is the index of the --output-dir value in args or -1.
+	outputDirIndex int
+	// remoteName is set when curl names output files itself (-O, -J).
+	remoteName bool
+}
+
+// outputFiles returns the files written by the tool inside the pod which have
+// to be copied back to the local machine, or nil when there are none.
+func outputFiles(kind PluginKind, args []string) *downloads {
+	switch kind {
+	case Curl:
+		return curlDownloads(args)
+	}
+	return nil
+}
+
+// curlDownloads returns the files written by curl options, i.e. "-o out.bin",
+// "-O", "-D headers.txt", "--trace trace.log" or "-c cookies.txt". Standard
+// output and error ("-", "%") and device files are left untouched.
+func curlDownloads(args []string) *downloads {
+	d := &downloads{outputDir: ".", outputDirIndex: -1}
+	for _, opt := range parseCurlOptions(args) {
+		switch opt.name {
+		case "output", "dump-header", "trace", "trace-ascii", "cookie-jar", "etag-save":
+			if opt.value == "-" || opt.value == "%" || opt.value == "" || strings.HasPrefix(opt.value, "/dev/") {
+				continue
+			}
+			d.refs = append(d.refs, fileRef{option: opt.name, index: opt.index, prefix: opt.prefix, path: opt.value})
+		case "output-dir":
+			d.outputDir = opt.value
+			d.outputDirIndex = opt.index
+		case "remote-name", "remote-name-all", "remote-header-name":
+			d.remoteName = true
+		}
+	}
+
+	if len(d.refs) == 0 && !d.remoteName {
+		return nil
+	}
+	return d
+}
+
+// prepareDownloads returns a copy of args in which the output files are
+// redirected to dir inside the pod. It returns the local paths of the
+// redirected files keyed by their names relative to dir.
+func prepareDownloads(d *downloads, args []string, dir string) ([]string, map[string]string) {
+	// curl resolves -o paths relative to --output-dir, which is required to
+	// redirect the files named by curl itself.
+	useOutputDir := d.remoteName || d.outputDirIndex != -1
+
+	rewritten := slices.Clone(args)
+	localPaths := map[string]string{}
+	for i, ref := range d.refs {
+		name := fmt.Sprintf("%d-%s", i, filepath.Base(ref.path))
+		localPath := ref.path
+		if ref.option == "output" && useOutputDir {
+			if !filepath.IsAbs(localPath) {
+				localPath = filepath.Join(d.outputDir, localPath)
+			}
+			rewritten[ref.index] = ref.prefix + name
+		} else {
+			rewritten[ref.index] = ref.prefix + path.Join(dir, name)
+		}
+		localPaths[name] = localPath
+	}
+
+	if d.outputDirIndex != -1 {
+		rewritten[d.outputDirIndex] = dir
+	} else if useOutputDir {
+		rewritten = append([]string{"--output-dir", dir}, rewritten...)
+	}
+
+	return rewritten, localPaths
+}
+
+// downloadFiles copies the files written to dir inside the pod back to the
+// local machine. Files not listed in localPaths were named by curl itself and
+// are written to the local output directory.
+func downloadFiles(ctx context.Context, container *apis.Container, logger *log.Logger, d *downloads, localPaths map[string]string, dir string, timeout time.Duration) error {
+	return container.CopyFrom(ctx, dir, func(name string, r io.Reader) error {
+		localPath, ok := localPaths[name]
+		if !ok {
+			localPath = filepath.Join(d.outputDir, filepath.Base(name))
+		}
+		logger.Printf("Downloading \"%s\" from the pod.\n", localPath)
+
+		file, err := os.Create(localPath)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, r); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}, timeout)
+}
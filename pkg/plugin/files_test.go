@@ -0,0 +1,320 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles creates the named files with some content in dir.
+func writeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Error creating directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatalf("Error writing file: %v", err)
+		}
+	}
+}
+
+func TestCurlInputFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "body.json", "image.png", "client.pem", "ca.pem")
+	body := filepath.Join(dir, "body.json")
+	image := filepath.Join(dir, "image.png")
+	cert := filepath.Join(dir, "client.pem")
+	ca := filepath.Join(dir, "ca.pem")
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected []fileRef
+	}{
+		{
+			name:     "data with separate value",
+			args:     []string{"-d", "@" + body, "http://example.com"},
+			expected: []fileRef{{option: "data", index: 1, prefix: "@", path: body}},
+		},
+		{
+			name:     "data with attached value",
+			args:     []string{"-d@" + body, "http://example.com"},
+			expected: []fileRef{{option: "data", index: 0, prefix: "-d@", path: body}},
+		},
+		{
+			name:     "upload in a short option cluster",
+			args:     []string{"-sST", image, "http://example.com/"},
+			expected: []fileRef{{option: "upload-file", index: 1, path: image}},
+		},
+		{
+			name:     "form with content type",
+			args:     []string{"-F", "x=@" + image + ";type=image/png", "http://example.com"},
+			expected: []fileRef{{option: "form", index: 1, prefix: "x=@", path: image, suffix: ";type=image/png"}},
+		},
+		{
+			name:     "form with content read from file",
+			args:     []string{"-F", "x=<" + body, "http://example.com"},
+			expected: []fileRef{{option: "form", index: 1, prefix: "x=<", path: body}},
+		},
+		{
+			name:     "data urlencode with name",
+			args:     []string{"--data-urlencode", "q@" + body, "http://example.com"},
+			expected: []fileRef{{option: "data-urlencode", index: 1, prefix: "q@", path: body}},
+		},
+		{
+			name:     "cert with password",
+			args:     []string{"--cert", cert + ":secret", "http://example.com"},
+			expected: []fileRef{{option: "cert", index: 1, path: cert, suffix: ":secret"}},
+		},
+		{
+			name: "cert without password and cacert",
+			args: []string{"-E", cert, "--cacert", ca, "https://example.com"},
+			expected: []fileRef{
+				{option: "cert", index: 1, path: cert},
+				{option: "cacert", index: 3, path: ca},
+			},
+		},
+		{
+			name:     "values which are not files",
+			args:     []string{"-d", "@-", "-d", "{}", "-b", "a=b", "-F", "x=y", "-T", ".", "-d", "@" + filepath.Join(dir, "missing.json"), "http://example.com"},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refs := curlInputFiles(tt.args)
+			if !reflect.DeepEqual(refs, tt.expected) {
+				t.Errorf("curlInputFiles(%q) = %+v, expected %+v", tt.args, refs, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPlanUploads(t *testing.T) {
+	tests := []struct {
+		name          string
+		refs          []fileRef
+		args          []string
+		expectedArgs  []string
+		expectedFiles map[string]string
+	}{
+		{
+			name: "files keep their base names",
+			refs: []fileRef{
+				{option: "form", index: 1, prefix: "x=@", path: "images/image.png", suffix: ";type=image/png"},
+				{option: "upload-file", index: 3, path: "/data/body.json"},
+			},
+			args:         []string{"-F", "x=@images/image.png;type=image/png", "-T", "/data/body.json", "http://example.com/"},
+			expectedArgs: []string{"-F", "x=@/tmp/dir/0/image.png;type=image/png", "-T", "/tmp/dir/1/body.json", "http://example.com/"},
+			expectedFiles: map[string]string{
+				"0/image.png": "images/image.png",
+				"1/body.json": "/data/body.json",
+			},
+		},
+		{
+			name: "same file referenced twice is uploaded once",
+			refs: []fileRef{
+				{option: "data", index: 0, prefix: "-d@", path: "body.json"},
+				{option: "upload-file", index: 2, path: "body.json"},
+			},
+			args:          []string{"-d@body.json", "-T", "body.json", "http://example.com/"},
+			expectedArgs:  []string{"-d@/tmp/dir/0/body.json", "-T", "/tmp/dir/0/body.json", "http://example.com/"},
+			expectedFiles: map[string]string{"0/body.json": "body.json"},
+		},
+		{
+			name: "directory tree",
+			refs: []fileRef{
+				{option: "import-path", index: 1, path: "protos", files: []string{"api.proto", "types/types.proto"}},
+			},
+			args:         []string{"-import-path", "protos", "-proto", "api.proto", "localhost:50051", "Service/Method"},
+			expectedArgs: []string{"-import-path", "/tmp/dir/0/protos", "-proto", "api.proto", "localhost:50051", "Service/Method"},
+			expectedFiles: map[string]string{
+				"0/protos/api.proto":         filepath.Join("protos", "api.proto"),
+				"0/protos/types/types.proto": filepath.Join("protos", "types", "types.proto"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, files := planUploads(tt.refs, tt.args, "/tmp/dir")
+			if !reflect.DeepEqual(args, tt.expectedArgs) {
+				t.Errorf("planUploads() args = %q, expected %q", args, tt.expectedArgs)
+			}
+			if !reflect.DeepEqual(files, tt.expectedFiles) {
+				t.Errorf("planUploads() files = %v, expected %v", files, tt.expectedFiles)
+			}
+		})
+	}
+}
+
+func TestCurlDownloads(t *testing.T) {
+	tests := []struct {
+		name               string
+		args               []string
+		expectedArgs       []string
+		expectedLocalPaths map[string]string
+	}{
+		{
+			name:               "relative output",
+			args:               []string{"-o", "out.bin", "http://example.com"},
+			expectedArgs:       []string{"-o", "/tmp/dir/0-out.bin", "http://example.com"},
+			expectedLocalPaths: map[string]string{"0-out.bin": "out.bin"},
+		},
+		{
+			name:               "absolute output",
+			args:               []string{"-o", "/data/out.bin", "http://example.com"},
+			expectedArgs:       []string{"-o", "/tmp/dir/0-out.bin", "http://example.com"},
+			expectedLocalPaths: map[string]string{"0-out.bin": "/data/out.bin"},
+		},
+		{
+			name:         "short option cluster with attached output and header dump",
+			args:         []string{"-sSLoout.bin", "-D", "headers.txt", "http://example.com"},
+			expectedArgs: []string{"-sSLo/tmp/dir/0-out.bin", "-D", "/tmp/dir/1-headers.txt", "http://example.com"},
+			expectedLocalPaths: map[string]string{
+				"0-out.bin":     "out.bin",
+				"1-headers.txt": "headers.txt",
+			},
+		},
+		{
+			name:               "remote name without output dir",
+			args:               []string{"-O", "http://example.com/file.bin"},
+			expectedArgs:       []string{"--output-dir", "/tmp/dir", "-O", "http://example.com/file.bin"},
+			expectedLocalPaths: map[string]string{},
+		},
+		{
+			name:               "remote name with output dir",
+			args:               []string{"-O", "--output-dir", "downloads", "http://example.com/file.bin"},
+			expectedArgs:       []string{"-O", "--output-dir", "/tmp/dir", "http://example.com/file.bin"},
+			expectedLocalPaths: map[string]string{},
+		},
+		{
+			name:         "relative and absolute output with output dir",
+			args:         []string{"--output-dir", "downloads", "-o", "out.bin", "http://example.com/a", "-o", "/data/out.bin", "http://example.com/b"},
+			expectedArgs: []string{"--output-dir", "/tmp/dir", "-o", "0-out.bin", "http://example.com/a", "-o", "1-out.bin", "http://example.com/b"},
+			expectedLocalPaths: map[string]string{
+				"0-out.bin": filepath.Join("downloads", "out.bin"),
+				"1-out.bin": "/data/out.bin",
+			},
+		},
+		{
+			name:         "relative output with remote name",
+			args:         []string{"-O", "http://example.com/a", "-o", "out.bin", "http://example.com/b"},
+			expectedArgs: []string{"--output-dir", "/tmp/dir", "-O", "http://example.com/a", "-o", "0-out.bin", "http://example.com/b"},
+			expectedLocalPaths: map[string]string{
+				"0-out.bin": "out.bin",
+			},
+		},
+		{
+			name: "standard streams and devices",
+			args: []string{"-o", "-", "-D", "%", "--trace", "/dev/stderr", "http://example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := curlDownloads(tt.args)
+			if tt.expectedArgs == nil {
+				if d != nil {
+					t.Errorf("curlDownloads(%q) = %+v, expected nil", tt.args, d)
+				}
+				return
+			}
+			if d == nil {
+				t.Fatalf("curlDownloads(%q) = nil", tt.args)
+			}
+
+			args, localPaths := prepareDownloads(d, tt.args, "/tmp/dir")
+			if !reflect.DeepEqual(args, tt.expectedArgs) {
+				t.Errorf("prepareDownloads() args = %q, expected %q", args, tt.expectedArgs)
+			}
+			if !reflect.DeepEqual(localPaths, tt.expectedLocalPaths) {
+				t.Errorf("prepareDownloads() local paths = %v, expected %v", localPaths, tt.expectedLocalPaths)
+			}
+		})
+	}
+}
+
+func TestGrpcurlInputFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "types/types.proto", "service.protoset", "ca.pem")
+	protos := filepath.Join(dir, "protos")
+	if err := os.Mkdir(protos, 0o755); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	api := "syntax = \"proto3\";\nimport \"types/types.proto\";\nimport public \"google/protobuf/empty.proto\";\n"
+	for _, path := range []string{filepath.Join(dir, "api.proto"), filepath.Join(protos, "api.proto")} {
+		if err := os.WriteFile(path, []byte(api), 0o644); err != nil {
+			t.Fatalf("Error writing file: %v", err)
+		}
+	}
+	protoset := filepath.Join(dir, "service.protoset")
+	ca := filepath.Join(dir, "ca.pem")
+
+	// Without -import-path grpcurl resolves -proto files from the current
+	// directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Error changing working directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	tests := []struct {
+		name         string
+		args         []string
+		expectedArgs []string
+		expected     []fileRef
+	}{
+		{
+			name:         "proto without import path",
+			args:         []string{"-proto", "api.proto", "localhost:50051", "Service/Method"},
+			expectedArgs: []string{"-import-path", ".", "-proto", "api.proto", "localhost:50051", "Service/Method"},
+			expected: []fileRef{
+				{option: "import-path", index: 1, path: ".", files: []string{"api.proto", "types/types.proto"}},
+			},
+		},
+		{
+			name:         "proto with import paths",
+			args:         []string{"-import-path", protos, "-import-path=" + dir, "-proto", "api.proto", "localhost:50051", "Service/Method"},
+			expectedArgs: []string{"-import-path", protos, "-import-path=" + dir, "-proto", "api.proto", "localhost:50051", "Service/Method"},
+			expected: []fileRef{
+				{option: "import-path", index: 1, path: protos, files: []string{"api.proto"}},
+				{option: "import-path", index: 2, prefix: "-import-path=", path: dir, files: []string{"types/types.proto"}},
+			},
+		},
+		{
+			name:         "missing proto",
+			args:         []string{"-proto", "missing.proto", "localhost:50051", "Service/Method"},
+			expectedArgs: []string{"-import-path", ".", "-proto", "missing.proto", "localhost:50051", "Service/Method"},
+			expected:     nil,
+		},
+		{
+			name:         "protoset and certificates",
+			args:         []string{"-protoset", protoset, "--cacert=" + ca, "-cert", "missing.pem", "localhost:50051", "Service/Method", "-key", ca},
+			expectedArgs: []string{"-protoset", protoset, "--cacert=" + ca, "-cert", "missing.pem", "localhost:50051", "Service/Method", "-key", ca},
+			expected: []fileRef{
+				{option: "protoset", index: 1, path: protoset},
+				{option: "cacert", index: 2, prefix: "--cacert=", path: ca},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, refs := grpcurlInputFiles(tt.args)
+			if !reflect.DeepEqual(args, tt.expectedArgs) {
+				t.Errorf("grpcurlInputFiles(%q) args = %q, expected %q", tt.args, args, tt.expectedArgs)
+			}
+			if !reflect.DeepEqual(refs, tt.expected) {
+				t.Errorf("grpcurlInputFiles(%q) refs = %+v, expected %+v", tt.args, refs, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"text/tabwriter"
+	"time"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/ptr"
+)
+
+// ExpiresAtAnnotation holds the RFC 3339 time after which a plugin pod is
+// expired and can be garbage collected.
+const ExpiresAtAnnotation = "kubectl-curl/expires-at"
+
+// expiryMutation limits the lifetime of the plugin pod to ttl with
+// activeDeadlineSeconds and records its expiry time. A zero ttl keeps the
+// pod running until it is deleted.
+func expiryMutation(ttl time.Duration) apis.Mutation {
+	return func(pod *apiv1.Pod) error {
+		if ttl <= 0 {
+			return nil
+		}
+		pod.Spec.ActiveDeadlineSeconds = ptr.To(int64(ttl.Seconds()))
+		pod.Annotations[ExpiresAtAnnotation] = time.Now().Add(ttl).UTC().Format(time.RFC3339)
+		return nil
+	}
+}
+
+type GCOpts struct {
+	Kubeconfig string
+	Context    string
+	// Namespace limits the garbage collection to a single namespace, all
+	// namespaces are searched when empty.
+	Namespace string
+	All       bool
+	DryRun    bool
+}
+
+// RunGC lists the plugin pods on out and deletes the expired ones, or all of
+// them with opts.All. Pods are expired after their expiry time or when they
+// terminated, i.e. after exceeding their active deadline.
+func RunGC(ctx context.Context, kind PluginKind, logger *log.Logger, opts *GCOpts, out io.Writer) error {
+	_, _, clientset, err := newClient(logger, opts.Kubeconfig, opts.Context)
+	if err != nil {
+		return err
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{ManagedByLabel: ManagedBy(kind)}).String()
+	pods, err := clientset.CoreV1().Pods(opts.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("error listing %s pods: %w", kind, err)
+	}
+
+	deleteOptions := metav1.DeleteOptions{}
+	if opts.DryRun {
+		deleteOptions.DryRun = []string{metav1.DryRunAll}
+	}
+
+	failed := 0
+	now := time.Now()
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tCREATED BY\tEXPIRES AT\tSTATUS\tRESULT")
+	for _, pod := range pods.Items {
+		expired, status := podExpiry(&pod, now)
+
+		result := "kept"
+		if expired || opts.All {
+			logger.Printf("Deleting pod \"%s/%s\".\n", pod.Namespace, pod.Name)
+			result = "deleted"
+			if err := clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, deleteOptions); err != nil {
+				result = fmt.Sprintf("error: %s", err)
+				failed++
+			} else if opts.DryRun {
+				result = "deleted (dry run)"
+			}
+		}
+
+		expiresAt := pod.Annotations[ExpiresAtAnnotation]
+		if expiresAt == "" {
+			expiresAt = "<none>"
+		}
+		createdBy := pod.Annotations[CreatedByAnnotation]
+		if createdBy == "" {
+			createdBy = "<unknown>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", pod.Namespace, pod.Name, createdBy, expiresAt, status, result)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d %s pods", failed, kind)
+	}
+	return nil
+}
+
+// expiresWithin reports whether the pod expires within d after now.
+func expiresWithin(pod *apiv1.Pod, now time.Time, d time.Duration) bool {
+	expiry, err := time.Parse(time.RFC3339, pod.Annotations[ExpiresAtAnnotation])
+	return err == nil && now.Add(d).After(expiry)
+}
+
+// podExpiry reports whether the plugin pod is expired at now and describes
+// its state.
+func podExpiry(pod *apiv1.Pod, now time.Time) (bool, string) {
+	switch pod.Status.Phase {
+	case apiv1.PodFailed, apiv1.PodSucceeded:
+		return true, string(pod.Status.Phase)
+	}
+
+	expiresAt, ok := pod.Annotations[ExpiresAtAnnotation]
+	if !ok {
+		return false, "no expiry"
+	}
+	expiry, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return false, "invalid expiry"
+	}
+	if now.After(expiry) {
+		return true, "expired"
+	}
+	return false, "expires in " + expiry.Sub(now).Round(time.Second).String()
+}
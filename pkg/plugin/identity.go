@@ -0,0 +1,133 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// AsWorkloadLabel marks plugin pods impersonating the network identity
+	// of a workload, whose reference is stored in AsWorkloadAnnotation.
+	AsWorkloadLabel      = "kubectl-curl/as-workload"
+	AsWorkloadAnnotation = "kubectl-curl/as-workload"
+
+	// neverReadyCondition is a pod readiness gate which is never set, so that
+	// pods with the labels of a workload are not added to its Services.
+	neverReadyCondition = "kubectl-curl/never-ready"
+)
+
+// controllerLabels lists the pod labels set by workload controllers, which
+// are not copied from the workload.
+var controllerLabels = []string{
+	ManagedByLabel,
+	"apps.kubernetes.io/pod-index",
+	"controller-revision-hash",
+	"pod-template-generation",
+	"pod-template-hash",
+	"statefulset.kubernetes.io/pod-name",
+}
+
+// meshAnnotationPrefixes lists the prefixes of the pod annotations which
+// configure service mesh sidecars and traffic interception.
+var meshAnnotationPrefixes = []string{
+	"sidecar.istio.io/",
+	"proxy.istio.io/",
+	"traffic.sidecar.istio.io/",
+	"istio.io/",
+	"linkerd.io/",
+	"config.linkerd.io/",
+	"kuma.io/",
+	"traffic.kuma.io/",
+	"consul.hashicorp.com/",
+}
+
+// workloadIdentityMutation returns a mutation giving the plugin pod the
+// network identity of workload: its pod labels, service account and service
+// mesh annotations. A readiness gate keeps the pod out of the workload
+// Services endpoints. The pod has no controller, so that PodDisruptionBudgets
+// of the workload ignore it, and when a controller of the workload would
+// adopt it, a label required by its selector is not copied.
+func workloadIdentityMutation(ctx context.Context, clientset *kubernetes.Clientset, logger *log.Logger, workload *apis.Workload) (apis.Mutation, error) {
+	template, err := workload.Template(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceAccountName := template.Spec.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = "default"
+	}
+	if _, err := clientset.CoreV1().ServiceAccounts(workload.Namespace()).Get(ctx, serviceAccountName, metav1.GetOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to get service account of %s: %w", workload, err)
+	}
+
+	podLabels := map[string]string{}
+	for key, value := range template.Labels {
+		if !slices.Contains(controllerLabels, key) {
+			podLabels[key] = value
+		}
+	}
+
+	selector, err := workload.AdoptingSelector(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if selector != nil && selector.Matches(labels.Set(podLabels)) {
+		key, err := adoptionLabel(selector, podLabels)
+		if err != nil {
+			return nil, fmt.Errorf("pods with the labels of %s would be adopted by its controller: %w", workload, err)
+		}
+		logger.Printf("Not copying label \"%s\" of %s, its controller would adopt the pod.\n", key, workload)
+		delete(podLabels, key)
+	}
+
+	logger.Printf("Using network identity of %s with service account \"%s\".\n", workload, serviceAccountName)
+
+	return func(pod *apiv1.Pod) error {
+		for key, value := range podLabels {
+			pod.Labels[key] = value
+		}
+		pod.Labels[AsWorkloadLabel] = "true"
+
+		for key, value := range template.Annotations {
+			for _, prefix := range meshAnnotationPrefixes {
+				if strings.HasPrefix(key, prefix) {
+					pod.Annotations[key] = value
+					break
+				}
+			}
+		}
+		pod.Annotations[AsWorkloadAnnotation] = workload.String()
+
+		pod.Spec.ServiceAccountName = serviceAccountName
+		pod.Spec.ReadinessGates = append(pod.Spec.ReadinessGates, apiv1.PodReadinessGate{
+			ConditionType: neverReadyCondition,
+		})
+		return nil
+	}, nil
+}
+
+// adoptionLabel returns the key of a label in podLabels required by
+// selector, without which the pod no longer matches it.
+func adoptionLabel(selector labels.Selector, podLabels map[string]string) (string, error) {
+	requirements, _ := selector.Requirements()
+	for _, requirement := range requirements {
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In, selection.Exists:
+			if _, ok := podLabels[requirement.Key()]; ok {
+				return requirement.Key(), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("selector \"%s\" requires no label", selector)
+}
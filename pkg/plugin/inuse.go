@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// InUseAnnotationPrefix prefixes the annotations with which invocations of
+// the plugin mark a shared plugin pod as in use. Every invocation sets its
+// own annotation, so the marks never conflict, and the annotation value is
+// the time until which the mark is valid, so that the marks of interrupted
+// invocations expire.
+const InUseAnnotationPrefix = "kubectl-curl/in-use-"
+
+// inUseSteps is the number of operations limited by the timeout which an
+// invocation runs after renewing its in use mark: the creation of the scratch
+// directory and the copy of input files, the creation of the output
+// directory, the command, the copy of output files and the removal of the
+// scratch directory.
+const inUseSteps = 6
+
+// inUseMargin covers the termination of timed out commands and the API
+// requests between the operations.
+const inUseMargin = time.Minute
+
+// inUseDuration returns how long an invocation with the operation timeout
+// marks the pod as in use. The mark is set when the pod is reconciled and
+// renewed once it is ready, before the command runs, so it covers at most
+// inUseSteps operations.
+func inUseDuration(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return time.Hour
+	}
+	return inUseSteps*timeout + inUseMargin
+}
+
+// acquirePod marks the pod as in use by the holder invocation, or renews the
+// mark, and returns the marked pod. Expired marks left by interrupted
+// invocations are removed at the same time, only when the pod is unchanged
+// since it was read, so that marks renewed meanwhile are kept. A missing pod
+// is reported as a NotFound error.
+func acquirePod(ctx context.Context, pod *apis.Pod, holder string, timeout time.Duration) (*apiv1.Pod, error) {
+	for attempt := 1; ; attempt++ {
+		existing, err := pod.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			return nil, apierrors.NewNotFound(apiv1.Resource("pods"), pod.Name())
+		}
+
+		now := time.Now()
+		until := now.Add(inUseDuration(timeout)).UTC().Format(time.RFC3339)
+		annotations := map[string]*string{InUseAnnotationPrefix + holder: &until}
+		var resourceVersion string
+		for key, value := range existing.Annotations {
+			if id, found := strings.CutPrefix(key, InUseAnnotationPrefix); found && id != holder && markExpired(value, now) {
+				annotations[key] = nil
+				resourceVersion = existing.ResourceVersion
+			}
+		}
+
+		marked, err := pod.SetAnnotations(ctx, annotations, resourceVersion)
+		if !apierrors.IsConflict(err) || attempt == maxAttempts {
+			return marked, err
+		}
+	}
+}
+
+// releasePod removes the in use mark of the holder invocation from the pod.
+func releasePod(ctx context.Context, pod *apis.Pod, holder string) error {
+	_, err := pod.SetAnnotations(ctx, map[string]*string{InUseAnnotationPrefix + holder: nil}, "")
+	return err
+}
+
+// podUsers returns the number of invocations other than holder which use the
+// pod at now.
+func podUsers(pod *apiv1.Pod, holder string, now time.Time) int {
+	users := 0
+	for key, value := range pod.Annotations {
+		id, found := strings.CutPrefix(key, InUseAnnotationPrefix)
+		if found && id != holder && !markExpired(value, now) {
+			users++
+		}
+	}
+	return users
+}
+
+// markExpired reports whether the in use mark with value is no longer valid
+// at now.
+func markExpired(value string, now time.Time) bool {
+	until, err := time.Parse(time.RFC3339, value)
+	return err != nil || !now.Before(until)
+}
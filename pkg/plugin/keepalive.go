@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// Keep-alive modes of the plugin pod, which has to keep running between the
+// executed commands.
+const (
+	// KeepAliveAuto runs sleep from the tool image and falls back to
+	// KeepAliveInject when the image has no sleep.
+	KeepAliveAuto = "auto"
+	// KeepAliveSleep runs sleep from the tool image.
+	KeepAliveSleep = "sleep"
+	// KeepAliveInject runs sleep from a busybox binary copied from
+	// KeepAliveImage into a shared volume by an init container, for minimal
+	// and distroless tool images.
+	KeepAliveInject = "inject"
+)
+
+var keepAliveModes = []string{KeepAliveAuto, KeepAliveSleep, KeepAliveInject}
+
+// KeepAliveImage is the image providing the static busybox binary injected
+// into the plugin pod.
+const KeepAliveImage = "busybox:1.36.1"
+
+// KeepAliveAnnotation records the keep-alive mode of plugin pods created with
+// the injected busybox, so that later invocations reuse them.
+const KeepAliveAnnotation = "kubectl-curl/keep-alive"
+
+const (
+	utilsVolume = "kubectl-curl-utils"
+	utilsDir    = "/opt/kubectl-curl"
+	// injectedUtils is the path of the injected busybox binary, which also
+	// provides the helper commands used to copy files and terminate commands.
+	injectedUtils = utilsDir + "/busybox"
+)
+
+func checkKeepAlive(mode string) error {
+	if !slices.Contains(keepAliveModes, mode) {
+		return fmt.Errorf("unknown keep-alive mode \"%s\", must be one of: %v", mode, keepAliveModes)
+	}
+	return nil
+}
+
+// keepAlive selects how the plugin pod is kept running. inject may be changed
+// between generations of the pod.
+type keepAlive struct {
+	image  string
+	inject bool
+}
+
+// mutation returns a mutation which, when inject is set, adds the init
+// container copying busybox into the volume shared with the tool container
+// and makes the tool container run its sleep.
+func (k *keepAlive) mutation() apis.Mutation {
+	return func(pod *apiv1.Pod) error {
+		if !k.inject {
+			return nil
+		}
+
+		mount := apiv1.VolumeMount{
+			Name:      utilsVolume,
+			MountPath: utilsDir,
+		}
+		pod.Spec.Volumes = append(pod.Spec.Volumes, apiv1.Volume{
+			Name: utilsVolume,
+			VolumeSource: apiv1.VolumeSource{
+				EmptyDir: &apiv1.EmptyDirVolumeSource{},
+			},
+		})
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, apiv1.Container{
+			Name:         "keep-alive",
+			Image:        k.image,
+			Command:      []string{"cp", "/bin/busybox", injectedUtils},
+			VolumeMounts: []apiv1.VolumeMount{mount},
+		})
+
+		container := &pod.Spec.Containers[0]
+		container.Command = []string{injectedUtils, "sleep", "infinity"}
+		container.VolumeMounts = append(container.VolumeMounts, mount)
+
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[KeepAliveAnnotation] = KeepAliveInject
+		return nil
+	}
+}
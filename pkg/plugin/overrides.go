@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+)
+
+// overridesMutation returns a mutation applying overrides, inline JSON/YAML
+// or "@file" with JSON/YAML content, to the generated pod as a strategic
+// merge patch, like "kubectl run --overrides".
+func overridesMutation(logger *log.Logger, overrides string) (apis.Mutation, error) {
+	data := []byte(overrides)
+	if path, found := strings.CutPrefix(overrides, "@"); found {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading overrides file: %w", err)
+		}
+	}
+
+	patch, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing overrides: %w", err)
+	}
+	var patchObject map[string]interface{}
+	if err := json.Unmarshal(patch, &patchObject); err != nil {
+		return nil, fmt.Errorf("error parsing overrides: overrides must be a JSON/YAML object: %w", err)
+	}
+
+	logged := false
+	return func(pod *apiv1.Pod) error {
+		original, err := json.Marshal(pod)
+		if err != nil {
+			return err
+		}
+
+		merged, err := strategicpatch.StrategicMergePatch(original, patch, apiv1.Pod{})
+		if err != nil {
+			return fmt.Errorf("error applying overrides: %w", err)
+		}
+
+		var result apiv1.Pod
+		decoder := json.NewDecoder(bytes.NewReader(merged))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&result); err != nil {
+			return fmt.Errorf("error applying overrides: %w", err)
+		}
+		*pod = result
+
+		if !logged {
+			logged = true
+			if podYAML, err := yaml.Marshal(pod); err == nil {
+				logger.Printf("Pod after applying overrides:\n%s", podYAML)
+			}
+		}
+
+		return nil
+	}, nil
+}
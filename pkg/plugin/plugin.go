@@ -1,15 +1,19 @@
 package plugin
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 )
@@ -26,14 +30,39 @@ func (p PluginKind) String() string {
 }
 
 type Opts struct {
-	Kubeconfig string
-	Context    string
-	Image      string
-	Namespace  string
-	PodName    string
-	Cleanup    bool
-	Verbose    bool
-	Timeout    int
+	Kubeconfig       string
+	Context          string
+	Image            string
+	ImagePullSecrets []string
+	ImagePullPolicy  string
+	RegistryMirror   string
+	Namespace        string
+	PodName          string
+	Cleanup          bool
+	Wait             bool
+	Unique           bool
+	Mode             string
+	KeepAlive        string
+	Verbose          bool
+	Timeout          int
+	Overrides        string
+	SecurityProfile  string
+	Node             string
+	NodeSelector     []string
+	Tolerations      []string
+	SameNodeAs       string
+	OtherNodeThan    string
+	Zone             string
+	HostNetwork      bool
+	AsWorkload       string
+	Target           string
+	ExecIn           string
+	TTL              time.Duration
+	// Version is the plugin version recorded on the plugin pods.
+	Version string
+	// DefaultImage is the image used unless --image is given, which is
+	// rewritten onto the registry mirror.
+	DefaultImage string
 }
 
 func GetKubeconfig(kubeconfig string) string {
@@ -49,68 +78,164 @@ func GetKubeconfig(kubeconfig string) string {
 	return ""
 }
 
-func RunPlugin(kind PluginKind, logger *log.Logger, opts *Opts, args []string) error {
-	curlCommand := kind.String() + " " + strings.Join(args, " ")
-	timeout := time.Duration(opts.Timeout) * time.Second
-
-	kubeconfig := GetKubeconfig(opts.Kubeconfig)
+// newClient builds the client configuration from the kubeconfig file and
+// context, the current context when empty, and a clientset using it.
+func newClient(logger *log.Logger, kubeconfigPath string, kubeContext string) (clientcmd.ClientConfig, *rest.Config, *kubernetes.Clientset, error) {
+	kubeconfig := GetKubeconfig(kubeconfigPath)
 	logger.Printf("Using kubeconfig: %s\n", kubeconfig)
 
 	var configOverrides clientcmd.ConfigOverrides
-	if opts.Context != "" {
-		configOverrides.CurrentContext = opts.Context
+	if kubeContext != "" {
+		configOverrides.CurrentContext = kubeContext
 	}
-	config, err := clientcmd.
+	clientConfig := clientcmd.
 		NewNonInteractiveDeferredLoadingClientConfig(
 			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
-			&configOverrides).ClientConfig()
+			&configOverrides)
+	config, err := clientConfig.ClientConfig()
 	if err != nil {
-		return fmt.Errorf("error building kubeconfig: %w", err)
+		return nil, nil, nil, fmt.Errorf("error building kubeconfig: %w", err)
 	}
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		return fmt.Errorf("error creating clientset: %w", err)
+		return nil, nil, nil, fmt.Errorf("error creating clientset: %w", err)
 	}
 
-	pod := apis.NewPod(
-		clientset,
-		config,
-		logger,
-		opts.Image,
-		opts.Namespace,
-		opts.PodName,
-		[]string{"sleep", "infinity"},
-		0)
+	return clientConfig, config, clientset, nil
+}
 
-	podExists, err := pod.IsCreated()
+// cleanupGracePeriod bounds the cleanup of plugin resources after the plugin
+// is interrupted.
+const cleanupGracePeriod = 10 * time.Second
+
+// cleanupContext returns a context for cleanup which is not cancelled with
+// ctx, so that resources are released after an interruption, but which is
+// cancelled cleanupGracePeriod after ctx is done.
+func cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	cleanupCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(cleanupGracePeriod, cancel)
+	})
+	return cleanupCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+func RunPlugin(ctx context.Context, kind PluginKind, logger *log.Logger, opts *Opts, args []string) (err error) {
+	timeout := time.Duration(opts.Timeout) * time.Second
+
+	clientConfig, config, clientset, err := newClient(logger, opts.Kubeconfig, opts.Context)
 	if err != nil {
-		return fmt.Errorf("error checking if \"%s\" exists: %w", opts.PodName, err)
+		return err
 	}
 
-	if !podExists {
-		if err := pod.Create(); err != nil {
-			return fmt.Errorf("error creating \"%s\" pod: %w", opts.PodName, err)
-		}
+	if err := checkMode(opts.Mode); err != nil {
+		return err
+	}
+	if err := resolveImageOptions(clientConfig, logger, opts); err != nil {
+		return err
+	}
+	if opts.Mode == ModeRun {
+		return runOnce(ctx, clientset, config, clientConfig, logger, kind, opts, args, timeout)
+	}
+
+	var b backend
+	if opts.ExecIn != "" {
+		b, err = newExecBackend(ctx, clientset, config, logger, kind, opts, timeout)
+	} else if opts.Target != "" {
+		b, err = newEphemeralBackend(ctx, clientset, config, logger, kind, opts, timeout)
 	} else {
-		logger.Printf("Pod \"%s\" already exists.", opts.PodName)
+		b, err = newPodBackend(ctx, clientset, config, clientConfig, logger, kind, opts, timeout)
 	}
+	if err != nil {
+		return err
+	}
+
+	// Cleanup runs on all paths, also after an interruption, and its error is
+	// reported together with the original one.
+	defer func() {
+		cleanupCtx, cancel := cleanupContext(ctx)
+		defer cancel()
+		if cleanupErr := b.Cleanup(cleanupCtx); cleanupErr != nil {
+			err = errors.Join(err, fmt.Errorf("error cleaning up: %w", cleanupErr))
+		}
+	}()
 
-	if err := pod.WaitForReady(timeout); err != nil {
-		return fmt.Errorf("error waiting for \"%s\" readiness: %w", opts.PodName, err)
+	container, err := b.Prepare(ctx)
+	if err != nil {
+		return err
 	}
 
-	output, err := pod.ExecuteCommand(curlCommand, timeout)
+	exitErr, err := runCommand(ctx, container, logger, kind, args, timeout)
 	if err != nil {
-		return fmt.Errorf("error executing command inside \"%s\" pod: %w", opts.PodName, err)
+		return err
+	}
+	if exitErr != nil {
+		return exitErr
 	}
-	fmt.Println(output)
 
-	if opts.Cleanup {
-		if err := pod.Delete(); err != nil {
-			return fmt.Errorf("error deleting \"%s\" pod: %w", opts.PodName, err)
+	return nil
+}
+
+// runCommand executes the curl/grpcurl command with args in container,
+// copying the local files referenced by args to the container and the files
+// written by the command back. A non-zero exit code of the command is
+// returned as the exit error.
+func runCommand(ctx context.Context, container *apis.Container, logger *log.Logger, kind PluginKind, args []string, timeout time.Duration) (*apis.ExitError, error) {
+	var err error
+	dir := scratchDir(kind)
+	args, refs := inputFiles(kind, args)
+	outputs := outputFiles(kind, args)
+	if len(refs) > 0 || outputs != nil {
+		defer func() {
+			cleanupCtx, cancel := cleanupContext(ctx)
+			defer cancel()
+			if err := container.RemovePath(cleanupCtx, dir, timeout); err != nil {
+				logger.Printf("Failed to remove scratch directory: %s\n", err)
+			}
+		}()
+	}
+
+	if len(refs) > 0 {
+		args, err = uploadFiles(ctx, container, logger, refs, args, dir, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("error uploading files to %s: %w", container, err)
 		}
 	}
 
-	return nil
+	outputDir := path.Join(dir, "out")
+	var localPaths map[string]string
+	if outputs != nil {
+		args, localPaths = prepareDownloads(outputs, args, outputDir)
+		if err := container.MakeDir(ctx, outputDir, timeout); err != nil {
+			return nil, fmt.Errorf("error preparing output directory in %s: %w", container, err)
+		}
+	}
+
+	command := append([]string{kind.String()}, args...)
+
+	var stdin io.Reader
+	if referencesStdin(kind, args) || stdinIsPipe() {
+		logger.Println("Forwarding standard input to the pod.")
+		stdin = os.Stdin
+	}
+
+	err = container.ExecuteCommand(ctx, command, stdin, os.Stdout, os.Stderr, timeout)
+	var exitErr *apis.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("error executing command inside %s: %w", container, err)
+	}
+
+	if outputs != nil {
+		if err := downloadFiles(ctx, container, logger, outputs, localPaths, outputDir, timeout); err != nil {
+			if exitErr == nil {
+				return nil, fmt.Errorf("error downloading files from %s: %w", container, err)
+			}
+			logger.Printf("Failed to download output files: %s\n", err)
+		}
+	}
+
+	return exitErr, nil
 }
@@ -0,0 +1,203 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// permission is a verb on a core API resource, optionally on one of its
+// subresources. Resources of other API groups have the group set, and
+// permissions needed in another namespace than the checked one have the
+// namespace set. Permissions only needed to share the plugin pod between
+// invocations have shared set.
+type permission struct {
+	verb        string
+	resource    string
+	subresource string
+	group       string
+	namespace   string
+	shared      bool
+}
+
+func (p permission) String() string {
+	s := p.verb + " " + p.resource
+	if p.subresource != "" {
+		s += "/" + p.subresource
+	}
+	if p.namespace != "" {
+		s += fmt.Sprintf(" in namespace \"%s\"", p.namespace)
+	}
+	return s
+}
+
+// accessMode is a way of running the tool selected with flag, together with
+// the permissions it needs in the namespace. podOptions is set for the modes
+// creating a plugin pod, which accept the options of the plugin pod.
+type accessMode struct {
+	flag        string
+	podOptions  bool
+	permissions []permission
+}
+
+// accessModes lists the ways of running the tool in the order in which they
+// are suggested as alternatives.
+var accessModes = []accessMode{
+	{"--mode=" + ModeExec, true, []permission{
+		{verb: "get", resource: "pods"}, {verb: "list", resource: "pods"}, {verb: "watch", resource: "pods"},
+		{verb: "create", resource: "pods"}, {verb: "patch", resource: "pods", shared: true}, {verb: "delete", resource: "pods"},
+		{verb: "create", resource: "pods", subresource: "exec"},
+	}},
+	{"--mode=" + ModeRun, true, []permission{
+		{verb: "get", resource: "pods"}, {verb: "list", resource: "pods"}, {verb: "watch", resource: "pods"},
+		{verb: "create", resource: "pods"}, {verb: "delete", resource: "pods"},
+		{verb: "get", resource: "pods", subresource: "log"},
+	}},
+	{"--target", false, []permission{
+		{verb: "get", resource: "pods"}, {verb: "list", resource: "pods"}, {verb: "watch", resource: "pods"},
+		{verb: "update", resource: "pods", subresource: "ephemeralcontainers"},
+		{verb: "create", resource: "pods", subresource: "exec"},
+	}},
+	{"--exec-in", false, []permission{
+		{verb: "get", resource: "pods"}, {verb: "list", resource: "pods"},
+		{verb: "create", resource: "pods", subresource: "exec"},
+	}},
+}
+
+// podOptionPermissions returns the namespace of the plugin pod and the
+// permissions needed by the options of the plugin pod which look up
+// workloads: --as-workload, --same-node-as and --other-node-than.
+func podOptionPermissions(clientset *kubernetes.Clientset, logger *log.Logger, opts *Opts) (string, []permission, error) {
+	namespace := opts.Namespace
+	var permissions []permission
+	if opts.AsWorkload != "" {
+		workload, err := apis.NewWorkload(clientset, logger, opts.Namespace, opts.AsWorkload)
+		if err != nil {
+			return "", nil, err
+		}
+		namespace = workload.Namespace()
+		permissions = append(permissions, workloadPermissions(workload, namespace, false)...)
+		permissions = append(permissions, permission{verb: "get", resource: "serviceaccounts"})
+	}
+	for _, ref := range []string{opts.SameNodeAs, opts.OtherNodeThan} {
+		if ref == "" {
+			continue
+		}
+		workload, err := apis.NewWorkload(clientset, logger, namespace, ref)
+		if err != nil {
+			return "", nil, err
+		}
+		permissions = append(permissions, workloadPermissions(workload, namespace, true)...)
+	}
+	return namespace, permissions, nil
+}
+
+// workloadPermissions returns the permissions needed to get workload and,
+// with listPods, to list its pods. Permissions in namespace have no
+// namespace set.
+func workloadPermissions(workload *apis.Workload, namespace string, listPods bool) []permission {
+	ns := workload.Namespace()
+	if ns == namespace {
+		ns = ""
+	}
+	var group string
+	if workload.Kind() != "pod" {
+		group = "apps"
+	}
+	permissions := []permission{{verb: "get", resource: workload.Kind() + "s", group: group, namespace: ns}}
+	if listPods && workload.Kind() != "pod" {
+		permissions = append(permissions, permission{verb: "list", resource: "pods", namespace: ns})
+	}
+	return permissions
+}
+
+// checkPermissions verifies with SelfSubjectAccessReviews that the user has
+// the permissions needed by the access mode selected with flag in namespace,
+// together with the extra permissions needed by the plugin options. With
+// unique, the permissions only needed for shared plugin pods are not
+// checked. When some are missing, the error lists them together with the alternative
+// modes which the user is allowed to use. The check is skipped when the
+// reviews can not be created.
+func checkPermissions(ctx context.Context, clientset *kubernetes.Clientset, logger *log.Logger, namespace string, flag string, unique bool, extra []permission) error {
+	allowed := map[permission]bool{}
+	review := func(p permission) (bool, error) {
+		if result, ok := allowed[p]; ok {
+			return result, nil
+		}
+		ns := namespace
+		if p.namespace != "" {
+			ns = p.namespace
+		}
+		sar, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   ns,
+					Verb:        p.verb,
+					Group:       p.group,
+					Resource:    p.resource,
+					Subresource: p.subresource,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return false, err
+		}
+		allowed[p] = sar.Status.Allowed
+		return sar.Status.Allowed, nil
+	}
+	missing := func(mode accessMode) ([]string, error) {
+		var permissions []string
+		for _, p := range append(slices.Clone(mode.permissions), extra...) {
+			if unique && p.shared {
+				continue
+			}
+			ok, err := review(p)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				permissions = append(permissions, p.String())
+			}
+		}
+		return permissions, nil
+	}
+
+	var selected accessMode
+	for _, mode := range accessModes {
+		if mode.flag == flag {
+			selected = mode
+		}
+	}
+
+	missingPermissions, err := missing(selected)
+	if err != nil {
+		logger.Printf("Skipping permissions check, failed to review access: %s\n", err)
+		return nil
+	}
+	if len(missingPermissions) == 0 {
+		return nil
+	}
+
+	var alternatives []string
+	for _, mode := range accessModes {
+		if mode.flag == flag || len(extra) > 0 && mode.podOptions != selected.podOptions {
+			continue
+		}
+		if permissions, err := missing(mode); err == nil && len(permissions) == 0 {
+			alternatives = append(alternatives, mode.flag)
+		}
+	}
+
+	message := fmt.Sprintf("missing permissions for %s in namespace \"%s\": %s", flag, namespace, strings.Join(missingPermissions, ", "))
+	if len(alternatives) > 0 {
+		message += fmt.Sprintf("; your permissions allow %s", strings.Join(alternatives, " or "))
+	}
+	return fmt.Errorf("%s", message)
+}
@@ -0,0 +1,157 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// ManagedByLabel marks the pods created by the plugins.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// CreatedByAnnotation holds the name of the user who created a plugin pod.
+	CreatedByAnnotation = "kubectl-curl/created-by"
+	// VersionAnnotation holds the version of the plugin which created a plugin
+	// pod.
+	VersionAnnotation = "kubectl-curl/version"
+)
+
+// ManagedBy returns the value of the ManagedByLabel of pods created by the
+// plugin of the given kind.
+func ManagedBy(kind PluginKind) string {
+	return "kubectl-" + kind.String()
+}
+
+// currentUser returns the name of the user the plugin authenticates as. It
+// falls back to the kubeconfig user name of contextName, or of the current
+// context when empty, when the API server does not support SelfSubjectReview.
+func currentUser(ctx context.Context, clientset *kubernetes.Clientset, clientConfig clientcmd.ClientConfig, contextName string) string {
+	review, err := clientset.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err == nil && review.Status.UserInfo.Username != "" {
+		return review.Status.UserInfo.Username
+	}
+
+	rawConfig, err := clientConfig.RawConfig()
+	if err != nil {
+		return ""
+	}
+	if contextName == "" {
+		contextName = rawConfig.CurrentContext
+	}
+	if kubeContext, ok := rawConfig.Contexts[contextName]; ok {
+		return kubeContext.AuthInfo
+	}
+	return ""
+}
+
+// ownershipMutation stamps the plugin pod with the managed-by label, the
+// name of its creator and the plugin version.
+func ownershipMutation(kind PluginKind, creator string, version string) apis.Mutation {
+	return func(pod *apiv1.Pod) error {
+		pod.Labels[ManagedByLabel] = ManagedBy(kind)
+		if creator != "" {
+			pod.Annotations[CreatedByAnnotation] = creator
+		}
+		if version != "" {
+			pod.Annotations[VersionAnnotation] = version
+		}
+		return nil
+	}
+}
+
+// maxAttempts bounds the retries of operations racing with concurrent
+// invocations of the plugin using the same pod.
+const maxAttempts = 3
+
+// reconcilePod makes sure that a plugin pod matching the requested options
+// exists. An existing pod is reused when it was created by the same user with
+// the same spec and recreated when its spec drifted, unless other invocations
+// still use it, or it can not run anymore. Pods not managed by the plugin or
+// created by another user are never modified. A pod created concurrently by
+// another invocation is reused.
+func reconcilePod(ctx context.Context, pod *apis.Pod, logger *log.Logger, kind PluginKind, timeout time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		err := reconcilePodOnce(ctx, pod, logger, kind, timeout)
+		if !apierrors.IsAlreadyExists(err) || attempt == maxAttempts {
+			return err
+		}
+		logger.Printf("Pod \"%s\" was created concurrently, reusing it.\n", pod.Name())
+	}
+}
+
+func reconcilePodOnce(ctx context.Context, pod *apis.Pod, logger *log.Logger, kind PluginKind, timeout time.Duration) error {
+	name := pod.Name()
+	existing, err := pod.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("error checking if \"%s\" exists: %w", name, err)
+	}
+
+	if existing == nil {
+		if err := pod.Create(ctx); err != nil {
+			return fmt.Errorf("error creating \"%s\" pod: %w", name, err)
+		}
+		return nil
+	}
+
+	desired, err := pod.Object()
+	if err != nil {
+		return fmt.Errorf("error generating \"%s\" pod: %w", name, err)
+	}
+
+	if existing.Labels[ManagedByLabel] != ManagedBy(kind) {
+		return fmt.Errorf("pod \"%s\" already exists and is not managed by %s, use --name to choose another pod name", name, ManagedBy(kind))
+	}
+	if creator := existing.Annotations[CreatedByAnnotation]; creator != desired.Annotations[CreatedByAnnotation] {
+		return fmt.Errorf("pod \"%s\" already exists and was created by \"%s\", use --name to choose another pod name", name, creator)
+	}
+
+	now := time.Now()
+	var reason string
+	switch {
+	case existing.DeletionTimestamp != nil:
+		reason = "it is being deleted"
+	case existing.Status.Phase == apiv1.PodFailed || existing.Status.Phase == apiv1.PodSucceeded:
+		reason = fmt.Sprintf("it is in %s phase", existing.Status.Phase)
+	case existing.Annotations[apis.SpecHashAnnotation] != desired.Annotations[apis.SpecHashAnnotation]:
+		if users := podUsers(existing, "", now); users > 0 {
+			return fmt.Errorf("pod \"%s\" does not match the requested options but is in use by %d other invocations, retry later or use --unique", name, users)
+		}
+		reason = "its spec does not match the requested options"
+	case expiresWithin(existing, now, inUseDuration(timeout)):
+		// The pod would be terminated by its active deadline while the
+		// command runs.
+		if users := podUsers(existing, "", now); users > 0 {
+			return fmt.Errorf("pod \"%s\" expires before the command could complete but is in use by %d other invocations, retry later or use --unique", name, users)
+		}
+		reason = "it expires before the command could complete"
+	}
+
+	if reason == "" {
+		logger.Printf("Pod \"%s\" already exists.", name)
+		return nil
+	}
+
+	logger.Printf("Recreating pod \"%s\" because %s.\n", name, reason)
+	if existing.DeletionTimestamp == nil {
+		if err := pod.Delete(ctx); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting \"%s\" pod: %w", name, err)
+		}
+	}
+	if err := pod.WaitForDeletion(ctx, timeout); err != nil {
+		return fmt.Errorf("error waiting for \"%s\" deletion: %w", name, err)
+	}
+	if err := pod.Create(ctx); err != nil {
+		return fmt.Errorf("error creating \"%s\" pod: %w", name, err)
+	}
+
+	return nil
+}
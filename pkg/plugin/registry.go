@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+	"sigs.k8s.io/yaml"
+)
+
+// imagePullPolicies lists the accepted values of --image-pull-policy.
+var imagePullPolicies = []apiv1.PullPolicy{apiv1.PullAlways, apiv1.PullIfNotPresent, apiv1.PullNever}
+
+// PluginConfig is the configuration file of the plugins holding per
+// kubeconfig context defaults of plugin flags.
+type PluginConfig struct {
+	Contexts map[string]ContextConfig `json:"contexts"`
+}
+
+// ContextConfig holds the defaults of plugin flags used with a kubeconfig
+// context. Flags given on the command line take precedence.
+type ContextConfig struct {
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+	ImagePullPolicy  string   `json:"imagePullPolicy,omitempty"`
+	RegistryMirror   string   `json:"registryMirror,omitempty"`
+}
+
+// GetPluginConfigPath returns the path of the plugin configuration file,
+// $KUBECTL_CURL_CONFIG or ~/.kube/kubectl-curl.yaml.
+func GetPluginConfigPath() string {
+	if path, exists := os.LookupEnv("KUBECTL_CURL_CONFIG"); exists {
+		return path
+	}
+	if home := homedir.HomeDir(); home != "" {
+		return filepath.Join(home, ".kube", "kubectl-curl.yaml")
+	}
+	return ""
+}
+
+// loadContextConfig returns the configuration of the kubeconfig context from
+// the plugin configuration file and whether it is configured there. A
+// missing file yields an empty configuration.
+func loadContextConfig(path string, contextName string) (ContextConfig, bool, error) {
+	if path == "" {
+		return ContextConfig{}, false, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ContextConfig{}, false, nil
+	}
+	if err != nil {
+		return ContextConfig{}, false, fmt.Errorf("error reading plugin config: %w", err)
+	}
+
+	var config PluginConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return ContextConfig{}, false, fmt.Errorf("error parsing plugin config \"%s\": %w", path, err)
+	}
+	contextConfig, found := config.Contexts[contextName]
+	return contextConfig, found, nil
+}
+
+// resolveImageOptions completes the image options not given on the command
+// line from the configuration of the kubeconfig context and rewrites the
+// default image onto the registry mirror. Configured options which can not
+// take effect with --target or --exec-in are not applied.
+func resolveImageOptions(clientConfig clientcmd.ClientConfig, logger *log.Logger, opts *Opts) error {
+	contextName := opts.Context
+	if contextName == "" {
+		if rawConfig, err := clientConfig.RawConfig(); err == nil {
+			contextName = rawConfig.CurrentContext
+		}
+	}
+
+	configPath := GetPluginConfigPath()
+	contextConfig, found, err := loadContextConfig(configPath, contextName)
+	if err != nil {
+		return err
+	}
+	if found {
+		logger.Printf("Using plugin config of context \"%s\" from %s\n", contextName, configPath)
+	}
+
+	if len(opts.ImagePullSecrets) == 0 && opts.Target == "" && opts.ExecIn == "" {
+		opts.ImagePullSecrets = contextConfig.ImagePullSecrets
+	}
+	if opts.ImagePullPolicy == "" && opts.ExecIn == "" {
+		opts.ImagePullPolicy = contextConfig.ImagePullPolicy
+	}
+	if opts.RegistryMirror == "" {
+		opts.RegistryMirror = contextConfig.RegistryMirror
+	}
+
+	if opts.ImagePullPolicy != "" && !slices.Contains(imagePullPolicies, apiv1.PullPolicy(opts.ImagePullPolicy)) {
+		return fmt.Errorf("unknown image pull policy \"%s\", must be one of: %v", opts.ImagePullPolicy, imagePullPolicies)
+	}
+	if opts.ImagePullPolicy != "" {
+		logger.Printf("Using image pull policy %s.\n", opts.ImagePullPolicy)
+	}
+	if len(opts.ImagePullSecrets) > 0 {
+		logger.Printf("Using image pull secrets: %s\n", strings.Join(opts.ImagePullSecrets, ", "))
+	}
+
+	if opts.RegistryMirror != "" && opts.Image == opts.DefaultImage {
+		opts.Image = mirrorImage(opts.RegistryMirror, opts.Image)
+		logger.Printf("Using image %s from registry mirror.\n", opts.Image)
+	}
+	return nil
+}
+
+// mirrorImage maps image onto the registry mirror, which is a registry host
+// optionally followed by a path prefix, the way pull-through caches lay out
+// Docker Hub images: "curlimages/curl:8.4.0" becomes
+// "<mirror>/curlimages/curl:8.4.0" and "busybox" "<mirror>/library/busybox".
+// The registry host of the image, if any, is replaced.
+func mirrorImage(mirror string, image string) string {
+	repository := image
+	if host, rest, found := strings.Cut(image, "/"); found && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		repository = rest
+	} else if !found {
+		repository = "library/" + image
+	}
+	return strings.TrimSuffix(mirror, "/") + "/" + repository
+}
+
+// imagePullMutation sets the image pull secrets of the plugin pod and the
+// pull policy of its containers.
+func imagePullMutation(secrets []string, policy string) apis.Mutation {
+	return func(pod *apiv1.Pod) error {
+		for _, secret := range secrets {
+			pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, apiv1.LocalObjectReference{Name: secret})
+		}
+		if policy != "" {
+			for i := range pod.Spec.InitContainers {
+				pod.Spec.InitContainers[i].ImagePullPolicy = apiv1.PullPolicy(policy)
+			}
+			for i := range pod.Spec.Containers {
+				pod.Spec.Containers[i].ImagePullPolicy = apiv1.PullPolicy(policy)
+			}
+		}
+		return nil
+	}
+}
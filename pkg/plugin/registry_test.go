@@ -0,0 +1,27 @@
+package plugin
+
+import "testing"
+
+func TestMirrorImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		mirror   string
+		image    string
+		expected string
+	}{
+		{name: "docker hub repository", mirror: "mirror.example.com", image: "curlimages/curl:8.4.0", expected: "mirror.example.com/curlimages/curl:8.4.0"},
+		{name: "docker hub official image", mirror: "mirror.example.com", image: "busybox:1.36", expected: "mirror.example.com/library/busybox:1.36"},
+		{name: "mirror with path prefix", mirror: "mirror.example.com/dockerhub/", image: "fullstorydev/grpcurl:v1.8.9-alpine", expected: "mirror.example.com/dockerhub/fullstorydev/grpcurl:v1.8.9-alpine"},
+		{name: "registry host", mirror: "mirror.example.com", image: "ghcr.io/org/tool:1.0", expected: "mirror.example.com/org/tool:1.0"},
+		{name: "registry host with port", mirror: "mirror.example.com", image: "registry:5000/tool@sha256:abc", expected: "mirror.example.com/tool@sha256:abc"},
+		{name: "localhost registry", mirror: "mirror.example.com", image: "localhost/tool", expected: "mirror.example.com/tool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := mirrorImage(tt.mirror, tt.image); result != tt.expected {
+				t.Errorf("mirrorImage(%q, %q) = %q, expected %q", tt.mirror, tt.image, result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Modes of running the tool.
+const (
+	// ModeExec executes the tool in a running container.
+	ModeExec = "exec"
+	// ModeRun runs the tool as the command of a one-shot pod, which needs no
+	// pods/exec permission.
+	ModeRun = "run"
+)
+
+var modes = []string{ModeExec, ModeRun}
+
+// runOnce runs the tool with args as the command of a one-shot plugin pod,
+// streams the pod logs to standard output and deletes the pod at the end.
+// A non-zero exit code of the tool is returned as an ExitError. Local files
+// and standard input can not be passed to the tool without exec.
+func runOnce(ctx context.Context, clientset *kubernetes.Clientset, config *rest.Config, clientConfig clientcmd.ClientConfig, logger *log.Logger, kind PluginKind, opts *Opts, args []string, timeout time.Duration) (err error) {
+	if opts.Target != "" || opts.ExecIn != "" {
+		return fmt.Errorf("--mode=%s can not be combined with --target and --exec-in", ModeRun)
+	}
+	if _, refs := inputFiles(kind, args); len(refs) > 0 {
+		return fmt.Errorf("local file \"%s\" can not be uploaded with --mode=%s, use --mode=%s", refs[0].path, ModeRun, ModeExec)
+	}
+	if outputFiles(kind, args) != nil {
+		return fmt.Errorf("output files can not be downloaded with --mode=%s, use --mode=%s", ModeRun, ModeExec)
+	}
+	if referencesStdin(kind, args) {
+		return fmt.Errorf("standard input can not be forwarded with --mode=%s, use --mode=%s", ModeRun, ModeExec)
+	}
+
+	namespace, permissions, err := podOptionPermissions(clientset, logger, opts)
+	if err != nil {
+		return err
+	}
+	if err := checkPermissions(ctx, clientset, logger, namespace, "--mode="+ModeRun, opts.Unique, permissions); err != nil {
+		return err
+	}
+
+	command := append([]string{kind.String()}, args...)
+	pod, err := newPluginPod(ctx, clientset, config, clientConfig, logger, kind, opts, command, apiv1.RestartPolicyNever, nil)
+	if err != nil {
+		return err
+	}
+	pod.GenerateName()
+
+	logger.Printf("Executing: %s", strings.Join(command, " "))
+	if err := pod.Create(ctx); err != nil {
+		return fmt.Errorf("error creating \"%s\" pod: %w", opts.PodName, err)
+	}
+	defer func() {
+		cleanupCtx, cancel := cleanupContext(ctx)
+		defer cancel()
+		if cleanupErr := deleteOnce(cleanupCtx, pod, opts.Wait, timeout); cleanupErr != nil {
+			err = errors.Join(err, fmt.Errorf("error cleaning up: %w", cleanupErr))
+		}
+	}()
+
+	if err := pod.WaitForStart(ctx, timeout); err != nil {
+		return fmt.Errorf("error waiting for \"%s\" to start: %w", pod.Name(), err)
+	}
+
+	logCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	logErr := pod.StreamLogs(logCtx, os.Stdout)
+
+	// The termination is checked first, as the logs of a container which
+	// could not start can not be streamed.
+	terminated, err := pod.WaitForTermination(ctx, timeout)
+	if errors.Is(err, apis.ErrCommandNotFound) {
+		return fmt.Errorf("%w: %w", binaryNotFound(kind, "image "+opts.Image, "use --image with an image containing it"), err)
+	}
+	if err != nil {
+		return fmt.Errorf("error waiting for \"%s\" to terminate: %w", pod.Name(), err)
+	}
+	if logErr != nil {
+		return fmt.Errorf("error streaming logs of \"%s\" pod: %w", pod.Name(), logErr)
+	}
+	if terminated.ExitCode != 0 {
+		logger.Printf("Pod container terminated: %s\n", strings.TrimSpace(terminated.Reason+" "+terminated.Message))
+		return &apis.ExitError{Code: int(terminated.ExitCode)}
+	}
+
+	return nil
+}
+
+// deleteOnce deletes the one-shot pod and, with wait, waits until it is gone.
+func deleteOnce(ctx context.Context, pod *apis.Pod, wait bool, timeout time.Duration) error {
+	if err := pod.Delete(ctx); err != nil {
+		return fmt.Errorf("error deleting \"%s\" pod: %w", pod.Name(), err)
+	}
+	if !wait {
+		return nil
+	}
+	if err := pod.WaitForDeletion(ctx, timeout); err != nil {
+		return fmt.Errorf("error waiting for \"%s\" deletion: %w", pod.Name(), err)
+	}
+	return nil
+}
+
+func checkMode(mode string) error {
+	if !slices.Contains(modes, mode) {
+		return fmt.Errorf("unknown mode \"%s\", must be one of: %v", mode, modes)
+	}
+	return nil
+}
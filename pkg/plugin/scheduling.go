@@ -0,0 +1,156 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// zoneLabel is the well-known node label holding the node zone.
+const zoneLabel = "topology.kubernetes.io/zone"
+
+// schedulingMutation returns a mutation placing the plugin pod on the node
+// requested with --node, on nodes matching --node-selector and --zone, on the
+// nodes of the --same-node-as workload and away from the nodes of the
+// --other-node-than workload, tolerating the --toleration taints.
+func schedulingMutation(ctx context.Context, clientset *kubernetes.Clientset, logger *log.Logger, opts *Opts) (apis.Mutation, error) {
+	nodeSelector := map[string]string{}
+	for _, selector := range opts.NodeSelector {
+		key, value, found := strings.Cut(selector, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid node selector \"%s\", expected key=value", selector)
+		}
+		nodeSelector[key] = value
+	}
+	if opts.Zone != "" {
+		nodeSelector[zoneLabel] = opts.Zone
+	}
+
+	var tolerations []apiv1.Toleration
+	for _, spec := range opts.Tolerations {
+		toleration, err := parseToleration(spec)
+		if err != nil {
+			return nil, err
+		}
+		tolerations = append(tolerations, toleration)
+	}
+
+	var sameNodes, otherNodes []string
+	for _, placement := range []struct {
+		ref   string
+		nodes *[]string
+	}{
+		{opts.SameNodeAs, &sameNodes},
+		{opts.OtherNodeThan, &otherNodes},
+	} {
+		if placement.ref == "" {
+			continue
+		}
+		workload, err := apis.NewWorkload(clientset, logger, opts.Namespace, placement.ref)
+		if err != nil {
+			return nil, err
+		}
+		nodeNames, err := workload.NodeNames(ctx)
+		if err != nil {
+			return nil, err
+		}
+		logger.Printf("Pods of %s are running on nodes: %s\n", workload, strings.Join(nodeNames, ", "))
+		*placement.nodes = nodeNames
+	}
+	terms := nodeNameTerms(sameNodes, otherNodes)
+
+	return func(pod *apiv1.Pod) error {
+		if opts.Node != "" {
+			pod.Spec.NodeName = opts.Node
+		}
+		if len(nodeSelector) > 0 {
+			if pod.Spec.NodeSelector == nil {
+				pod.Spec.NodeSelector = map[string]string{}
+			}
+			maps.Copy(pod.Spec.NodeSelector, nodeSelector)
+		}
+		pod.Spec.Tolerations = append(pod.Spec.Tolerations, tolerations...)
+		if len(terms) > 0 {
+			pod.Spec.Affinity = &apiv1.Affinity{
+				NodeAffinity: &apiv1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &apiv1.NodeSelector{
+						NodeSelectorTerms: terms,
+					},
+				},
+			}
+		}
+		return nil
+	}, nil
+}
+
+// nodeNameTerms returns the node selector terms matching the nodes named in
+// sameNodes, or any node when empty, except the nodes named in otherNodes.
+// The API server accepts a single value in "metadata.name" field
+// requirements, so every node of sameNodes gets its own term, which are
+// ORed, and every node of otherNodes its own requirement, repeated in each
+// term.
+func nodeNameTerms(sameNodes []string, otherNodes []string) []apiv1.NodeSelectorTerm {
+	if len(sameNodes) == 0 && len(otherNodes) == 0 {
+		return nil
+	}
+
+	var notIn []apiv1.NodeSelectorRequirement
+	for _, node := range otherNodes {
+		notIn = append(notIn, apiv1.NodeSelectorRequirement{
+			Key:      "metadata.name",
+			Operator: apiv1.NodeSelectorOpNotIn,
+			Values:   []string{node},
+		})
+	}
+	if len(sameNodes) == 0 {
+		return []apiv1.NodeSelectorTerm{{MatchFields: notIn}}
+	}
+
+	var terms []apiv1.NodeSelectorTerm
+	for _, node := range sameNodes {
+		requirements := []apiv1.NodeSelectorRequirement{{
+			Key:      "metadata.name",
+			Operator: apiv1.NodeSelectorOpIn,
+			Values:   []string{node},
+		}}
+		terms = append(terms, apiv1.NodeSelectorTerm{MatchFields: append(requirements, notIn...)})
+	}
+	return terms
+}
+
+// parseToleration parses a toleration given as "key[=value][:effect]", like
+// the taints of "kubectl taint". A key without a value tolerates any value of
+// the taint and "*" tolerates all taints.
+func parseToleration(spec string) (apiv1.Toleration, error) {
+	keyValue, effect, _ := strings.Cut(spec, ":")
+	toleration := apiv1.Toleration{
+		Operator: apiv1.TolerationOpExists,
+		Effect:   apiv1.TaintEffect(effect),
+	}
+
+	switch apiv1.TaintEffect(effect) {
+	case "", apiv1.TaintEffectNoSchedule, apiv1.TaintEffectPreferNoSchedule, apiv1.TaintEffectNoExecute:
+	default:
+		return toleration, fmt.Errorf("invalid toleration \"%s\", effect must be one of: NoSchedule, PreferNoSchedule, NoExecute", spec)
+	}
+
+	if keyValue == "*" {
+		return toleration, nil
+	}
+	key, value, found := strings.Cut(keyValue, "=")
+	if key == "" {
+		return toleration, fmt.Errorf("invalid toleration \"%s\", expected key[=value][:effect]", spec)
+	}
+	toleration.Key = key
+	if found {
+		toleration.Operator = apiv1.TolerationOpEqual
+		toleration.Value = value
+	}
+	return toleration, nil
+}
@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+func TestParseToleration(t *testing.T) {
+	tests := []struct {
+		spec        string
+		expected    apiv1.Toleration
+		expectedErr bool
+	}{
+		{spec: "dedicated", expected: apiv1.Toleration{Key: "dedicated", Operator: apiv1.TolerationOpExists}},
+		{spec: "dedicated=infra", expected: apiv1.Toleration{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: "infra"}},
+		{spec: "dedicated=:NoSchedule", expected: apiv1.Toleration{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Effect: apiv1.TaintEffectNoSchedule}},
+		{spec: "dedicated=infra:NoExecute", expected: apiv1.Toleration{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: "infra", Effect: apiv1.TaintEffectNoExecute}},
+		{spec: "node.kubernetes.io/unreachable:NoExecute", expected: apiv1.Toleration{Key: "node.kubernetes.io/unreachable", Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectNoExecute}},
+		{spec: "*", expected: apiv1.Toleration{Operator: apiv1.TolerationOpExists}},
+		{spec: "*:PreferNoSchedule", expected: apiv1.Toleration{Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectPreferNoSchedule}},
+		{spec: "dedicated:NoRun", expectedErr: true},
+		{spec: "=infra", expectedErr: true},
+		{spec: ":NoSchedule", expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			toleration, err := parseToleration(tt.spec)
+			if tt.expectedErr {
+				if err == nil {
+					t.Errorf("parseToleration(%q) = %+v, expected an error", tt.spec, toleration)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseToleration(%q) returned error: %v", tt.spec, err)
+			}
+			if toleration != tt.expected {
+				t.Errorf("parseToleration(%q) = %+v, expected %+v", tt.spec, toleration, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNodeNameTerms(t *testing.T) {
+	in := func(node string) apiv1.NodeSelectorRequirement {
+		return apiv1.NodeSelectorRequirement{Key: "metadata.name", Operator: apiv1.NodeSelectorOpIn, Values: []string{node}}
+	}
+	notIn := func(node string) apiv1.NodeSelectorRequirement {
+		return apiv1.NodeSelectorRequirement{Key: "metadata.name", Operator: apiv1.NodeSelectorOpNotIn, Values: []string{node}}
+	}
+
+	tests := []struct {
+		name       string
+		sameNodes  []string
+		otherNodes []string
+		expected   []apiv1.NodeSelectorTerm
+	}{
+		{
+			name:     "no placement",
+			expected: nil,
+		},
+		{
+			name:      "same nodes",
+			sameNodes: []string{"node-a", "node-b"},
+			expected: []apiv1.NodeSelectorTerm{
+				{MatchFields: []apiv1.NodeSelectorRequirement{in("node-a")}},
+				{MatchFields: []apiv1.NodeSelectorRequirement{in("node-b")}},
+			},
+		},
+		{
+			name:       "other nodes",
+			otherNodes: []string{"node-a", "node-b"},
+			expected: []apiv1.NodeSelectorTerm{
+				{MatchFields: []apiv1.NodeSelectorRequirement{notIn("node-a"), notIn("node-b")}},
+			},
+		},
+		{
+			name:       "same and other nodes",
+			sameNodes:  []string{"node-a", "node-b"},
+			otherNodes: []string{"node-c"},
+			expected: []apiv1.NodeSelectorTerm{
+				{MatchFields: []apiv1.NodeSelectorRequirement{in("node-a"), notIn("node-c")}},
+				{MatchFields: []apiv1.NodeSelectorRequirement{in("node-b"), notIn("node-c")}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			terms := nodeNameTerms(tt.sameNodes, tt.otherNodes)
+			if !reflect.DeepEqual(terms, tt.expected) {
+				t.Errorf("nodeNameTerms(%q, %q) = %+v, expected %+v", tt.sameNodes, tt.otherNodes, terms, tt.expected)
+			}
+		})
+	}
+}
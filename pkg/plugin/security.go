@@ -0,0 +1,155 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// Security profiles of plugin pods, named after the Pod Security Standards
+// levels they comply with.
+const (
+	ProfileRestricted = "restricted"
+	ProfileBaseline   = "baseline"
+	ProfilePrivileged = "privileged"
+)
+
+// securityProfiles lists the security profiles from the least to the most
+// restrictive one.
+var securityProfiles = []string{ProfilePrivileged, ProfileBaseline, ProfileRestricted}
+
+// podSecurityEnforceLabel is the namespace label holding the Pod Security
+// Admission level enforced in the namespace.
+const podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+
+// nonRootUser is the user and group plugin pods run as with the restricted
+// security profile.
+const nonRootUser = 65532
+
+// securityMutation returns a mutation applying the security profile to the
+// generated pod. The restricted profile runs all containers, including init
+// containers, as a non-root user without capabilities, privilege escalation
+// and with a read-only root filesystem, so a writable emptyDir volume is
+// mounted at the scratch directory of the main containers. The baseline and
+// privileged profiles keep the pod defaults.
+func securityMutation(profile string) (apis.Mutation, error) {
+	if !slices.Contains(securityProfiles, profile) {
+		return nil, fmt.Errorf("unknown security profile \"%s\", must be one of: %v", profile, securityProfiles)
+	}
+
+	return func(pod *apiv1.Pod) error {
+		if profile != ProfileRestricted {
+			return nil
+		}
+
+		pod.Spec.SecurityContext = &apiv1.PodSecurityContext{
+			RunAsNonRoot: ptr.To(true),
+			RunAsUser:    ptr.To(int64(nonRootUser)),
+			RunAsGroup:   ptr.To(int64(nonRootUser)),
+			SeccompProfile: &apiv1.SeccompProfile{
+				Type: apiv1.SeccompProfileTypeRuntimeDefault,
+			},
+		}
+		pod.Spec.Volumes = append(pod.Spec.Volumes, apiv1.Volume{
+			Name: "scratch",
+			VolumeSource: apiv1.VolumeSource{
+				EmptyDir: &apiv1.EmptyDirVolumeSource{},
+			},
+		})
+		for i := range pod.Spec.InitContainers {
+			pod.Spec.InitContainers[i].SecurityContext = restrictedSecurityContext()
+		}
+		for i := range pod.Spec.Containers {
+			container := &pod.Spec.Containers[i]
+			container.SecurityContext = restrictedSecurityContext()
+			container.VolumeMounts = append(container.VolumeMounts, apiv1.VolumeMount{
+				Name:      "scratch",
+				MountPath: scratchRoot,
+			})
+		}
+		return nil
+	}, nil
+}
+
+func restrictedSecurityContext() *apiv1.SecurityContext {
+	return &apiv1.SecurityContext{
+		AllowPrivilegeEscalation: ptr.To(false),
+		ReadOnlyRootFilesystem:   ptr.To(true),
+		Capabilities: &apiv1.Capabilities{
+			Drop: []apiv1.Capability{"ALL"},
+		},
+	}
+}
+
+// checkPodSecurity verifies that the Pod Security Admission level enforced
+// in namespace allows pods with the security profile, and host network pods
+// when hostNetwork is set. The check is skipped when the namespace can not
+// be read.
+func checkPodSecurity(ctx context.Context, clientset *kubernetes.Clientset, logger *log.Logger, namespace string, profile string, hostNetwork bool) error {
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("namespace \"%s\" not found", namespace)
+	}
+	if err != nil {
+		logger.Printf("Skipping Pod Security check, failed to read namespace \"%s\": %s\n", namespace, err)
+		return nil
+	}
+
+	level, ok := ns.Labels[podSecurityEnforceLabel]
+	if !ok {
+		return nil
+	}
+	logger.Printf("Namespace \"%s\" enforces Pod Security level \"%s\".\n", namespace, level)
+
+	if hostNetwork && level != ProfilePrivileged {
+		return fmt.Errorf("namespace \"%s\" enforces Pod Security level \"%s\" which does not allow host network pods, use a namespace with the \"%s\" level", namespace, level, ProfilePrivileged)
+	}
+	if slices.Index(securityProfiles, profile) < slices.Index(securityProfiles, level) {
+		return fmt.Errorf("namespace \"%s\" enforces Pod Security level \"%s\" which does not allow pods with the \"%s\" security profile, use --security-profile=%s", namespace, level, profile, level)
+	}
+	return nil
+}
+
+// hostNetworkMutation runs the plugin pod in the network namespace of its
+// node. Cluster DNS names are still resolved with the ClusterFirstWithHostNet
+// DNS policy.
+func hostNetworkMutation(pod *apiv1.Pod) error {
+	pod.Spec.HostNetwork = true
+	pod.Spec.DNSPolicy = apiv1.DNSClusterFirstWithHostNet
+	return nil
+}
+
+// ephemeralSecurityContext returns the security context of ephemeral
+// containers with the security profile. Ephemeral containers can not set the
+// pod security context nor mount new volumes, so with the restricted profile
+// the non-root user and seccomp profile are set on the container and the
+// root filesystem stays writable for the scratch directory.
+func ephemeralSecurityContext(profile string) (*apiv1.SecurityContext, error) {
+	if !slices.Contains(securityProfiles, profile) {
+		return nil, fmt.Errorf("unknown security profile \"%s\", must be one of: %v", profile, securityProfiles)
+	}
+	if profile != ProfileRestricted {
+		return nil, nil
+	}
+
+	return &apiv1.SecurityContext{
+		RunAsNonRoot:             ptr.To(true),
+		RunAsUser:                ptr.To(int64(nonRootUser)),
+		RunAsGroup:               ptr.To(int64(nonRootUser)),
+		AllowPrivilegeEscalation: ptr.To(false),
+		Capabilities: &apiv1.Capabilities{
+			Drop: []apiv1.Capability{"ALL"},
+		},
+		SeccompProfile: &apiv1.SeccompProfile{
+			Type: apiv1.SeccompProfileTypeRuntimeDefault,
+		},
+	}, nil
+}
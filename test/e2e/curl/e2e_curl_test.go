@@ -2,10 +2,13 @@ package e2e
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -13,21 +16,28 @@ import (
 	"github.com/michal-kopczynski/kubectl-curl/pkg/apis"
 	"github.com/michal-kopczynski/kubectl-curl/pkg/plugin"
 	"github.com/michal-kopczynski/kubectl-curl/test/e2e/pkg/testapis"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
-	testNamespaceName = "kubectl-curl-test"
-	httpbinPodName    = "httpbin"
-	httpbinImage      = "kennethreitz/httpbin"
+	testNamespaceName           = "kubectl-curl-test"
+	restrictedTestNamespaceName = "kubectl-curl-test-restricted"
+	httpbinPodName              = "httpbin"
+	httpbinImage                = "kennethreitz/httpbin"
+	noExecServiceAccountName    = "kubectl-curl-no-exec"
+	spreadDeploymentName        = "spread"
 )
 
 type TestState struct {
-	clientset      *kubernetes.Clientset
-	testNamespace  *testapis.Namespace
-	httpbinPod     *apis.Pod
-	httpbinService *testapis.Service
+	clientset               *kubernetes.Clientset
+	testNamespace           *testapis.Namespace
+	restrictedTestNamespace *testapis.Namespace
+	httpbinPod              *apis.Pod
+	httpbinService          *testapis.Service
+	noExecServiceAccount    *testapis.ServiceAccount
+	spreadDeployment        *testapis.Deployment
 }
 
 // Requires Kubernetes cluster which can be created using for example Minikube
@@ -52,7 +62,14 @@ func setup(t *testing.T) *TestState {
 		clientset,
 		config,
 		logger,
-		testNamespaceName)
+		testNamespaceName,
+		nil)
+	restrictedTestNamespace := testapis.NewNamespace(
+		clientset,
+		config,
+		logger,
+		restrictedTestNamespaceName,
+		map[string]string{"pod-security.kubernetes.io/enforce": "restricted"})
 	httpbinPod := apis.NewPod(
 		clientset,
 		config,
@@ -75,28 +92,67 @@ func setup(t *testing.T) *TestState {
 		t.Fatalf("Error creating test namespace: %v", err)
 	}
 
+	if err := restrictedTestNamespace.Create(); err != nil {
+		t.Fatalf("Error creating restricted test namespace: %v", err)
+	}
+
 	if err := httpbinService.Create(); err != nil {
 		t.Fatalf("Error creating httpbin service: %v", err)
 	}
 
-	if err := httpbinPod.Create(); err != nil {
+	// The service account is allowed to use --mode=run but not --mode=exec.
+	noExecServiceAccount := testapis.NewServiceAccount(
+		clientset,
+		config,
+		logger,
+		testNamespaceName,
+		noExecServiceAccountName,
+		[]rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list", "watch", "create", "delete"}},
+			{APIGroups: []string{""}, Resources: []string{"pods/log"}, Verbs: []string{"get"}},
+		})
+
+	if err := noExecServiceAccount.Create(); err != nil {
+		t.Fatalf("Error creating service account without exec permission: %v", err)
+	}
+
+	spreadDeployment := testapis.NewDeployment(
+		clientset,
+		config,
+		logger,
+		testNamespaceName,
+		spreadDeploymentName,
+		3)
+
+	if err := spreadDeployment.Create(); err != nil {
+		t.Fatalf("Error creating spread deployment: %v", err)
+	}
+
+	if err := httpbinPod.Create(context.Background()); err != nil {
 		t.Fatalf("Error creating httpbin pod: %v", err)
 	}
 
-	if err := httpbinPod.WaitForReady(30 * time.Second); err != nil {
+	if err := httpbinPod.WaitForReady(context.Background(), 30*time.Second); err != nil {
 		t.Fatalf("Error waiting for httpbin pod readiness: %v", err)
 	}
 
+	if err := spreadDeployment.WaitForReady(60 * time.Second); err != nil {
+		t.Fatalf("Error waiting for spread deployment readiness: %v", err)
+	}
+
 	return &TestState{
-		clientset:      clientset,
-		testNamespace:  testNamespace,
-		httpbinPod:     httpbinPod,
-		httpbinService: httpbinService,
+		clientset:               clientset,
+		testNamespace:           testNamespace,
+		restrictedTestNamespace: restrictedTestNamespace,
+		httpbinPod:              httpbinPod,
+		httpbinService:          httpbinService,
+		noExecServiceAccount:    noExecServiceAccount,
+		spreadDeployment:        spreadDeployment,
 	}
 }
 
 func teardown(t *testing.T, testState *TestState) {
-	if err := testState.httpbinPod.Delete(); err != nil {
+	if err := testState.httpbinPod.Delete(context.Background()); err != nil {
 		t.Fatalf("Error deleting httpbin pod: %v", err)
 	}
 
@@ -104,52 +160,341 @@ func teardown(t *testing.T, testState *TestState) {
 		t.Fatalf("Error deleting httpbin service: %v", err)
 	}
 
+	if err := testState.spreadDeployment.Delete(); err != nil {
+		t.Fatalf("Error deleting spread deployment: %v", err)
+	}
+
+	if err := testState.noExecServiceAccount.Delete(); err != nil {
+		t.Fatalf("Error deleting service account without exec permission: %v", err)
+	}
+
 	if err := testState.testNamespace.Delete(); err != nil {
 		t.Fatalf("Error deleting test namespace: %v", err)
 	}
 
+	if err := testState.restrictedTestNamespace.Delete(); err != nil {
+		t.Fatalf("Error deleting restricted test namespace: %v", err)
+	}
+
 }
 
 func TestKubectlCurl(t *testing.T) {
 	testState := setup(t)
 	defer teardown(t, testState)
 
+	payloadFile := filepath.Join(t.TempDir(), "payload.json")
+	if err := os.WriteFile(payloadFile, []byte(`{"uploaded": "from file"}`), 0o644); err != nil {
+		t.Fatalf("Error writing payload file: %v", err)
+	}
+
+	outputDir := t.TempDir()
+
+	kubeconfig, err := clientcmd.LoadFromFile(plugin.GetKubeconfig(""))
+	if err != nil {
+		t.Fatalf("Error loading kubeconfig: %v", err)
+	}
+	pluginConfigFile := filepath.Join(t.TempDir(), "kubectl-curl.yaml")
+	pluginConfig := "contexts:\n  " + kubeconfig.CurrentContext + ":\n    imagePullPolicy: IfNotPresent\n"
+	if err := os.WriteFile(pluginConfigFile, []byte(pluginConfig), 0o644); err != nil {
+		t.Fatalf("Error writing plugin config file: %v", err)
+	}
+
+	noExecKubeconfig := filepath.Join(t.TempDir(), "no-exec.kubeconfig")
+	if err := testState.noExecServiceAccount.WriteKubeconfig(noExecKubeconfig); err != nil {
+		t.Fatalf("Error writing kubeconfig of service account without exec permission: %v", err)
+	}
+
 	tests := []struct {
 		name             string
-		curlArgs         string
+		curlArgs         []string
+		env              []string
+		stdin            string
+		expectedExitCode int
 		expectedInOutput []string
+		expectedInFiles  map[string]string
 	}{
 		{
 			name:             "Test default plugin and curl options",
-			curlArgs:         "http://httpbin." + testNamespaceName + ".svc.cluster.local/ip",
+			curlArgs:         []string{"http://httpbin." + testNamespaceName + ".svc.cluster.local/ip"},
 			expectedInOutput: []string{"origin"},
 		},
 		{
 			name:             "Test including protocol response headers in curl options",
-			curlArgs:         "-i http://httpbin." + testNamespaceName + ".svc.cluster.local/ip",
+			curlArgs:         []string{"-i", "http://httpbin." + testNamespaceName + ".svc.cluster.local/ip"},
 			expectedInOutput: []string{"HTTP/1.1 200 OK", "origin"},
 		},
 		{
 			name:             "Test custom namespace option in plugin options",
-			curlArgs:         "-n " + testNamespaceName + " -- http://httpbin/ip",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "http://httpbin/ip"},
 			expectedInOutput: []string{"origin"},
 		},
 		{
 			name:             "Test verbose option in plugin options",
-			curlArgs:         "-v -n " + testNamespaceName + " -- http://httpbin/ip",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--", "http://httpbin/ip"},
 			expectedInOutput: []string{"Using kubeconfig", "Executing: curl http://httpbin/ip", "origin"},
 		},
+		{
+			name:             "Test header with spaces in curl options",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-H", "X-Test-Header: foo bar", "http://httpbin/headers"},
+			expectedInOutput: []string{`"X-Test-Header": "foo bar"`},
+		},
+		{
+			name:             "Test JSON payload with spaces in curl options",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-H", "Content-Type: application/json", "-d", `{"greeting": "hello world"}`, "http://httpbin/post"},
+			expectedInOutput: []string{`"greeting": "hello world"`, `"Content-Type": "application/json"`},
+		},
+		{
+			name:             "Test empty argument in curl options",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-d", "", "http://httpbin/post"},
+			expectedInOutput: []string{`"data": ""`, `"Content-Length": "0"`},
+		},
+		{
+			name:             "Test remote curl exit code is propagated",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "--fail", "http://httpbin/status/404"},
+			expectedExitCode: 22,
+			expectedInOutput: []string{},
+		},
+		{
+			name:             "Test curl verbose diagnostics are streamed",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-v", "http://httpbin/ip"},
+			expectedInOutput: []string{"> GET /ip HTTP/1.1", "< HTTP/1.1 200 OK", "origin"},
+		},
+		{
+			name:             "Test payload piped from standard input",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-H", "Content-Type: application/json", "--data-binary", "@-", "http://httpbin/post"},
+			stdin:            `{"piped": "from stdin"}`,
+			expectedInOutput: []string{`"piped": "from stdin"`},
+		},
+		{
+			name:             "Test payload uploaded from local file",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-H", "Content-Type: application/json", "-d", "@" + payloadFile, "http://httpbin/post"},
+			expectedInOutput: []string{`"uploaded": "from file"`},
+		},
+		{
+			name:             "Test form file uploaded from local file",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-F", "upload=@" + payloadFile + ";type=application/json", "--trace-ascii", "-", "http://httpbin/post"},
+			expectedInOutput: []string{`filename="payload.json"`, `"upload": "{\"uploaded\": \"from file\"}"`},
+		},
+		{
+			name:             "Test output files downloaded from the pod",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-o", filepath.Join(outputDir, "ip.json"), "-D", filepath.Join(outputDir, "headers.txt"), "http://httpbin/ip"},
+			expectedInOutput: []string{},
+			expectedInFiles: map[string]string{
+				filepath.Join(outputDir, "ip.json"):     "origin",
+				filepath.Join(outputDir, "headers.txt"): "HTTP/1.1 200 OK",
+			},
+		},
+		{
+			name:             "Test remote name output file downloaded from the pod",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-O", "--output-dir", outputDir, "http://httpbin/uuid"},
+			expectedInOutput: []string{},
+			expectedInFiles: map[string]string{
+				filepath.Join(outputDir, "uuid"): "uuid",
+			},
+		},
+		{
+			name:             "Test plugin pod with non-existent image fails fast",
+			curlArgs:         []string{"-n", testNamespaceName, "--image", "curlimages/curl:does-not-exist", "--name", "curl-bad-image", "--", "http://httpbin/ip"},
+			expectedExitCode: 125,
+			expectedInOutput: []string{"container \"curl-bad-image\" can not start", "Events:"},
+		},
+		{
+			name:             "Test plugin pod created with custom image",
+			curlArgs:         []string{"-n", testNamespaceName, "--image", "curlimages/curl:8.3.0", "--name", "curl-drift", "--", "http://httpbin/ip"},
+			expectedInOutput: []string{"origin"},
+		},
+		{
+			name:             "Test plugin pod recreated when image does not match",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--name", "curl-drift", "--", "http://httpbin/ip"},
+			expectedInOutput: []string{"Recreating pod \"curl-drift\" because its spec does not match the requested options", "origin"},
+		},
+		{
+			name:             "Test pod not managed by the plugin is not reused",
+			curlArgs:         []string{"-n", testNamespaceName, "--name", httpbinPodName, "--", "http://httpbin/ip"},
+			expectedExitCode: 125,
+			expectedInOutput: []string{"is not managed by kubectl-curl"},
+		},
+		{
+			name:             "Test pod overrides applied to plugin pod",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--name", "curl-overrides", "--overrides", `{"spec":{"containers":[{"name":"curl-overrides","env":[{"name":"E2E","value":"overridden"}]}]}}`, "--", "http://httpbin/ip"},
+			expectedInOutput: []string{"Pod after applying overrides", "value: overridden", "origin"},
+		},
+		{
+			name:             "Test invalid pod overrides are rejected",
+			curlArgs:         []string{"-n", testNamespaceName, "--name", "curl-overrides-invalid", "--overrides", `{"spec":{"tolerationz":[]}}`, "--", "http://httpbin/ip"},
+			expectedExitCode: 125,
+			expectedInOutput: []string{`unknown field "tolerationz"`},
+		},
+		{
+			name:             "Test default plugin pod in namespace enforcing restricted Pod Security",
+			curlArgs:         []string{"-n", restrictedTestNamespaceName, "--", "http://httpbin." + testNamespaceName + ".svc.cluster.local/ip"},
+			expectedInOutput: []string{"origin"},
+		},
+		{
+			name:             "Test baseline security profile rejected in namespace enforcing restricted Pod Security",
+			curlArgs:         []string{"-n", restrictedTestNamespaceName, "--security-profile", "baseline", "--name", "curl-baseline", "--", "http://httpbin." + testNamespaceName + ".svc.cluster.local/ip"},
+			expectedExitCode: 125,
+			expectedInOutput: []string{`enforces Pod Security level "restricted"`, "--security-profile=restricted"},
+		},
+		{
+			name:             "Test host network plugin pod rejected in namespace enforcing restricted Pod Security",
+			curlArgs:         []string{"-n", restrictedTestNamespaceName, "--host-network", "--name", "curl-host-network", "--", "http://httpbin." + testNamespaceName + ".svc.cluster.local/ip"},
+			expectedExitCode: 125,
+			expectedInOutput: []string{"does not allow host network pods"},
+		},
+		{
+			name:             "Test host network plugin pod resolving cluster DNS names",
+			curlArgs:         []string{"-n", testNamespaceName, "--host-network", "--name", "curl-host-network", "--cleanup", "--", "http://httpbin/ip"},
+			expectedInOutput: []string{"origin"},
+		},
+		{
+			name:             "Test plugin pod scheduled on the same node as a workload",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--name", "curl-same-node", "--same-node-as", "pod/" + httpbinPodName, "--", "http://httpbin/ip"},
+			expectedInOutput: []string{"Pods of " + testNamespaceName + "/pod/" + httpbinPodName + " are running on nodes", "Pod is now running on node", "origin"},
+		},
+		{
+			name:             "Test plugin pod scheduled with node selector and toleration",
+			curlArgs:         []string{"-n", testNamespaceName, "--name", "curl-node-selector", "--node-selector", "kubernetes.io/os=linux", "--toleration", "*", "--", "http://httpbin/ip"},
+			expectedInOutput: []string{"origin"},
+		},
+		{
+			name:             "Test plugin pod scheduled on the nodes of a multi-replica deployment",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--name", "curl-same-node-as-spread", "--same-node-as", "deploy/" + spreadDeploymentName, "--cleanup", "--", "http://httpbin/ip"},
+			expectedInOutput: []string{"Pods of " + testNamespaceName + "/deployment/" + spreadDeploymentName + " are running on nodes", "origin"},
+		},
+		{
+			name:             "Test plugin pod with network identity of a workload",
+			curlArgs:         []string{"-v", "--name", "curl-as-httpbin", "--as-workload", testNamespaceName + "/pod/" + httpbinPodName, "--", "http://httpbin/ip"},
+			expectedInOutput: []string{"Using network identity of " + testNamespaceName + "/pod/" + httpbinPodName + " with service account \"default\"", "origin"},
+		},
+		{
+			name:             "Test plugin pod recreated with network identity of another workload",
+			curlArgs:         []string{"-v", "--name", "curl-as-httpbin", "--as-workload", testNamespaceName + "/deploy/" + spreadDeploymentName, "--", "http://httpbin/ip"},
+			expectedInOutput: []string{`Recreating pod "curl-as-httpbin" because its spec does not match the requested options`, "origin"},
+		},
+		{
+			name:             "Test ephemeral container sharing the network namespace of a target pod",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--target", "pod/" + httpbinPodName, "--", "http://localhost:80/ip"},
+			expectedInOutput: []string{"Ephemeral container is now running", "origin"},
+		},
+		{
+			name:             "Test exec in the curl binary of an existing pod",
+			curlArgs:         []string{"-n", testNamespaceName, "--exec-in", "pod/curl-same-node", "--", "http://httpbin/ip"},
+			expectedInOutput: []string{"origin"},
+		},
+		{
+			name:             "Test plugin pod expiry recorded with ttl",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--name", "curl-ttl", "--ttl", "1h", "--cleanup", "--", "http://httpbin/ip"},
+			expectedInOutput: []string{"origin"},
+		},
+		{
+			name:             "Test plugin pod created with a ttl shorter than the in use duration",
+			curlArgs:         []string{"-n", testNamespaceName, "--name", "curl-short-ttl", "--ttl", "5m", "--timeout", "60", "--", "http://httpbin/ip"},
+			expectedInOutput: []string{"origin"},
+		},
+		{
+			name:             "Test plugin pod recreated when it expires before the command could complete",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--name", "curl-short-ttl", "--ttl", "5m", "--timeout", "60", "--cleanup", "--", "http://httpbin/ip"},
+			expectedInOutput: []string{`Recreating pod "curl-short-ttl" because it expires before the command could complete`, "origin"},
+		},
+		{
+			name:             "Test unique plugin pod with generated name",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--name", "curl-unique", "--unique", "--", "http://httpbin/ip"},
+			expectedInOutput: []string{`Pod "curl-unique-`, "Pod deleted successfully", "origin"},
+		},
+		{
+			name:             "Test shared plugin pod deleted at the end when not in use",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--name", "curl-shared", "--cleanup", "--", "http://httpbin/ip"},
+			expectedInOutput: []string{"Pod deleted successfully", "origin"},
+		},
+		{
+			name:             "Test one-shot run mode streaming the pod logs",
+			curlArgs:         []string{"-n", testNamespaceName, "--mode", "run", "--", "-s", "http://httpbin/ip"},
+			expectedInOutput: []string{"origin"},
+		},
+		{
+			name:             "Test one-shot run mode propagating the exit code",
+			curlArgs:         []string{"-n", testNamespaceName, "--mode", "run", "--", "--fail", "http://httpbin/status/404"},
+			expectedExitCode: 22,
+		},
+		{
+			name:             "Test one-shot run mode reporting an image without the curl binary",
+			curlArgs:         []string{"-n", testNamespaceName, "--mode", "run", "--image", "busybox:1.36.1", "--", "http://httpbin/ip"},
+			expectedExitCode: 125,
+			expectedInOutput: []string{"curl binary not found on the PATH of image busybox:1.36.1, use --image with an image containing it"},
+		},
+		{
+			name:             "Test one-shot run mode refusing local files",
+			curlArgs:         []string{"-n", testNamespaceName, "--mode", "run", "--", "-d", "@" + payloadFile, "http://httpbin/post"},
+			expectedExitCode: 125,
+			expectedInOutput: []string{"can not be uploaded with --mode=run"},
+		},
+		{
+			name:             "Test image pull policy from the plugin config of the context",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--name", "curl-pull-policy", "--cleanup", "--", "http://httpbin/ip"},
+			env:              []string{"KUBECTL_CURL_CONFIG=" + pluginConfigFile},
+			expectedInOutput: []string{`Using plugin config of context "` + kubeconfig.CurrentContext + `"`, "Using image pull policy IfNotPresent.", "origin"},
+		},
+		{
+			name:             "Test image pull policy flag taking precedence over the plugin config",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--name", "curl-pull-policy", "--image-pull-policy", "Always", "--cleanup", "--", "http://httpbin/ip"},
+			env:              []string{"KUBECTL_CURL_CONFIG=" + pluginConfigFile},
+			expectedInOutput: []string{"Using image pull policy Always.", "origin"},
+		},
+		{
+			name:             "Test image pull secret refused with an ephemeral container",
+			curlArgs:         []string{"-n", testNamespaceName, "--target", "pod/" + httpbinPodName, "--image-pull-secret", "regcred", "--", "http://localhost:80/ip"},
+			expectedExitCode: 125,
+			expectedInOutput: []string{"--target can not be combined with --image-pull-secret"},
+		},
+		{
+			name:             "Test missing exec permission reported before creating the plugin pod",
+			curlArgs:         []string{"--kubeconfig", noExecKubeconfig, "-n", testNamespaceName, "--name", "curl-no-exec", "--", "http://httpbin/ip"},
+			expectedExitCode: 125,
+			expectedInOutput: []string{"missing permissions for --mode=exec", "create pods/exec", "patch pods", "your permissions allow --mode=run"},
+		},
+		{
+			name:             "Test plugin pod deleted and waited for when the command fails",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--name", "curl-cleanup-on-error", "--cleanup", "--wait", "--", "--fail", "http://httpbin/status/404"},
+			expectedExitCode: 22,
+			expectedInOutput: []string{"Pod is now deleted"},
+		},
+		{
+			name:             "Test garbage collection of all plugin pods in dry run mode",
+			curlArgs:         []string{"gc", "--all", "--dry-run", "-n", testNamespaceName},
+			expectedInOutput: []string{"curl-same-node", "deleted (dry run)"},
+		},
+		{
+			name:             "Test garbage collection keeps plugin pods which are not expired",
+			curlArgs:         []string{"gc", "-n", testNamespaceName},
+			expectedInOutput: []string{"curl-same-node", "kept"},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			commandArgs := append([]string{"curl"}, strings.Split(tt.curlArgs, " ")...)
+			commandArgs := append([]string{"curl"}, tt.curlArgs...)
 			cmd := exec.Command("kubectl", commandArgs...)
+			cmd.Env = append(os.Environ(), tt.env...)
+			if tt.stdin != "" {
+				cmd.Stdin = strings.NewReader(tt.stdin)
+			}
 			var out bytes.Buffer
 			cmd.Stdout = &out
+			cmd.Stderr = &out
 			err := cmd.Run()
-			if err != nil {
-				t.Fatalf("Failed to run kubectl curl: %v", err)
+			if tt.expectedExitCode == 0 {
+				if err != nil {
+					t.Fatalf("Failed to run kubectl curl: %v", err)
+				}
+			} else {
+				var exitErr *exec.ExitError
+				if !errors.As(err, &exitErr) {
+					t.Fatalf("Expected kubectl curl to exit with code %d, got: %v", tt.expectedExitCode, err)
+				}
+				if exitErr.ExitCode() != tt.expectedExitCode {
+					t.Errorf("Expected exit code %d, got %d", tt.expectedExitCode, exitErr.ExitCode())
+				}
 			}
 
 			output := out.String()
@@ -160,6 +505,17 @@ func TestKubectlCurl(t *testing.T) {
 					t.Errorf("Expected output to contain %q. Output:\n%s", expected, output)
 				}
 			}
+
+			for path, expected := range tt.expectedInFiles {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					t.Errorf("Expected file %q to be downloaded: %v", path, err)
+					continue
+				}
+				if !strings.Contains(string(content), expected) {
+					t.Errorf("Expected file %q to contain %q. Content:\n%s", path, expected, content)
+				}
+			}
 		})
 	}
 }
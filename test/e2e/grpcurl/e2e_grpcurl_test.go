@@ -2,6 +2,7 @@ package e2e
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"log"
 	"os"
@@ -52,7 +53,8 @@ func setup(t *testing.T) *TestState {
 		clientset,
 		config,
 		logger,
-		testNamespaceName)
+		testNamespaceName,
+		nil)
 	httpbinPod := apis.NewPod(
 		clientset,
 		config,
@@ -79,11 +81,11 @@ func setup(t *testing.T) *TestState {
 		t.Fatalf("Error creating httpbin service: %v", err)
 	}
 
-	if err := httpbinPod.Create(); err != nil {
+	if err := httpbinPod.Create(context.Background()); err != nil {
 		t.Fatalf("Error creating httpbin pod: %v", err)
 	}
 
-	if err := httpbinPod.WaitForReady(30 * time.Second); err != nil {
+	if err := httpbinPod.WaitForReady(context.Background(), 30*time.Second); err != nil {
 		t.Fatalf("Error waiting for httpbin pod readiness: %v", err)
 	}
 
@@ -96,7 +98,7 @@ func setup(t *testing.T) *TestState {
 }
 
 func teardown(t *testing.T, testState *TestState) {
-	if err := testState.httpbinPod.Delete(); err != nil {
+	if err := testState.httpbinPod.Delete(context.Background()); err != nil {
 		t.Fatalf("Error deleting httpbin pod: %v", err)
 	}
 
@@ -115,42 +117,102 @@ func TestKubectlGrpcurl(t *testing.T) {
 
 	tests := []struct {
 		name             string
-		curlArgs         string
+		curlArgs         []string
+		stdin            string
 		expectToPass     bool
 		expectedInOutput []string
 	}{
 		{
 			name:             "Test default plugin options - TLS failure",
-			curlArgs:         `-d {"greeting":"world"} grpcbin.` + testNamespaceName + `.svc.cluster.local:80 hello.HelloService.SayHello`,
+			curlArgs:         []string{"-d", `{"greeting":"world"}`, "grpcbin." + testNamespaceName + ".svc.cluster.local:80", "hello.HelloService.SayHello"},
 			expectToPass:     false,
 			expectedInOutput: []string{"Failed to dial target host", "first record does not look like a TLS handshak"},
 		},
 		{
 			name:             "Test default plugin and grpcurl options",
-			curlArgs:         `-d {"greeting":"world"} -plaintext grpcbin.` + testNamespaceName + `.svc.cluster.local:80 hello.HelloService.SayHello`,
+			curlArgs:         []string{"-d", `{"greeting":"world"}`, "-plaintext", "grpcbin." + testNamespaceName + ".svc.cluster.local:80", "hello.HelloService.SayHello"},
 			expectToPass:     true,
 			expectedInOutput: []string{"hello world"},
 		},
 		{
 			name:             "Test custom namespace option in plugin options",
-			curlArgs:         `-n ` + testNamespaceName + ` -- -d {"greeting":"world"} -plaintext grpcbin:80 hello.HelloService.SayHello`,
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-d", `{"greeting":"world"}`, "-plaintext", "grpcbin:80", "hello.HelloService.SayHello"},
 			expectToPass:     true,
 			expectedInOutput: []string{"hello world"},
 		},
 		{
 			name:             "Test verbose option in plugin options",
-			curlArgs:         `-v -n ` + testNamespaceName + ` -- -d {"greeting":"world"} -plaintext grpcbin:80 hello.HelloService.SayHello`,
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--", "-d", `{"greeting":"world"}`, "-plaintext", "grpcbin:80", "hello.HelloService.SayHello"},
 			expectToPass:     true,
 			expectedInOutput: []string{"Using kubeconfig", "Executing: grpcurl -d {\"greeting\":\"world\"} -plaintext grpcbin:80 hello.HelloService.SayHello", "hello world"},
 		},
+		{
+			name:             "Test JSON payload with spaces in grpcurl options",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-d", `{"greeting": "big world"}`, "-plaintext", "grpcbin:80", "hello.HelloService.SayHello"},
+			expectToPass:     true,
+			expectedInOutput: []string{"hello big world"},
+		},
+		{
+			name:             "Test header with spaces in grpcurl options",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-H", "x-test-header: foo bar", "-plaintext", "grpcbin:80", "grpcbin.GRPCBin/HeadersUnary"},
+			expectToPass:     true,
+			expectedInOutput: []string{"foo bar"},
+		},
+		{
+			name:             "Test empty payload in grpcurl options",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-d", "", "-plaintext", "grpcbin:80", "hello.HelloService.SayHello"},
+			expectToPass:     true,
+			expectedInOutput: []string{"hello "},
+		},
+		{
+			name:             "Test payload piped from standard input",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-d", "@", "-plaintext", "grpcbin:80", "hello.HelloService.SayHello"},
+			stdin:            `{"greeting":"stdin"}`,
+			expectToPass:     true,
+			expectedInOutput: []string{"hello stdin"},
+		},
+		{
+			name:             "Test local proto files uploaded to the pod",
+			curlArgs:         []string{"-n", testNamespaceName, "--", "-import-path", "testdata/protos", "-proto", "hello.proto", "-d", `{"greeting":"proto"}`, "-plaintext", "grpcbin:80", "hello.HelloService.SayHello"},
+			expectToPass:     true,
+			expectedInOutput: []string{"hello proto"},
+		},
+		{
+			name:             "Test image without sleep kept alive with injected busybox",
+			curlArgs:         []string{"-v", "-n", testNamespaceName, "--name", "grpcurl-scratch", "--image", "fullstorydev/grpcurl:v1.8.9", "--cleanup", "--", "-d", `{"greeting":"scratch"}`, "-plaintext", "grpcbin:80", "hello.HelloService.SayHello"},
+			expectToPass:     true,
+			expectedInOutput: []string{"has no sleep, recreating pod", "hello scratch"},
+		},
+		{
+			name:             "Test image without sleep and keep-alive with sleep of the image",
+			curlArgs:         []string{"-n", testNamespaceName, "--name", "grpcurl-scratch-sleep", "--image", "fullstorydev/grpcurl:v1.8.9", "--keep-alive", "sleep", "--cleanup", "--", "-plaintext", "grpcbin:80", "list"},
+			expectToPass:     false,
+			expectedInOutput: []string{"container command not found in the image"},
+		},
+		{
+			name:             "Test image without sleep refused for an ephemeral container",
+			curlArgs:         []string{"-n", testNamespaceName, "--target", "pod/" + httpbinPodName, "--image", "fullstorydev/grpcurl:v1.8.9", "--", "-plaintext", "localhost:9000", "list"},
+			expectToPass:     false,
+			expectedInOutput: []string{"has no sleep to keep the ephemeral container running"},
+		},
+		{
+			name:             "Test image without the grpcurl binary",
+			curlArgs:         []string{"-n", testNamespaceName, "--name", "grpcurl-busybox", "--image", "busybox:1.36.1", "--cleanup", "--", "-plaintext", "grpcbin:80", "list"},
+			expectToPass:     false,
+			expectedInOutput: []string{"grpcurl binary not found on the PATH", "use --image with an image containing it"},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			commandArgs := append([]string{"grpcurl"}, strings.Split(tt.curlArgs, " ")...)
+			commandArgs := append([]string{"grpcurl"}, tt.curlArgs...)
 			cmd := exec.Command("kubectl", commandArgs...)
+			if tt.stdin != "" {
+				cmd.Stdin = strings.NewReader(tt.stdin)
+			}
 			var out bytes.Buffer
 			cmd.Stdout = &out
+			cmd.Stderr = &out
 			err := cmd.Run()
 			if tt.expectToPass {
 				if err != nil {
@@ -0,0 +1,99 @@
+package testapis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
+)
+
+// Deployment is a deployment of replicas sleeping pods, spread across the
+// nodes when possible.
+type Deployment struct {
+	clientset *kubernetes.Clientset
+	config    *rest.Config
+	logger    *log.Logger
+	namespace string
+	name      string
+	replicas  int32
+}
+
+func NewDeployment(clientset *kubernetes.Clientset, config *rest.Config, logger *log.Logger, namespace string, name string, replicas int32) *Deployment {
+	return &Deployment{
+		clientset: clientset,
+		config:    config,
+		logger:    logger,
+		namespace: namespace,
+		name:      name,
+		replicas:  replicas,
+	}
+}
+
+func (p *Deployment) Create() error {
+	labels := map[string]string{"app": p.name}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: p.name,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To(p.replicas),
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: apiv1.PodSpec{
+					Containers: []apiv1.Container{{
+						Name:    p.name,
+						Image:   "busybox:1.36.1",
+						Command: []string{"sleep", "infinity"},
+					}},
+					TopologySpreadConstraints: []apiv1.TopologySpreadConstraint{{
+						MaxSkew:           1,
+						TopologyKey:       "kubernetes.io/hostname",
+						WhenUnsatisfiable: apiv1.ScheduleAnyway,
+						LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+					}},
+				},
+			},
+		},
+	}
+
+	_, err := p.clientset.AppsV1().Deployments(p.namespace).Create(context.TODO(), deployment, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create deployment: %w", err)
+	}
+
+	p.logger.Printf("Deployment \"%s\" created successfully in namespace \"%s\".\n", p.name, p.namespace)
+	return nil
+}
+
+// WaitForReady waits until all replicas of the deployment are ready.
+func (p *Deployment) WaitForReady(timeout time.Duration) error {
+	err := wait.PollUntilContextTimeout(context.TODO(), time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		deployment, err := p.clientset.AppsV1().Deployments(p.namespace).Get(ctx, p.name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return deployment.Status.ReadyReplicas == p.replicas, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for deployment readiness: %w", err)
+	}
+	return nil
+}
+
+func (p *Deployment) Delete() error {
+	err := p.clientset.AppsV1().Deployments(p.namespace).Delete(context.TODO(), p.name, metav1.DeleteOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to delete deployment: %w", err)
+	}
+
+	return nil
+}
@@ -16,21 +16,24 @@ type Namespace struct {
 	config    *rest.Config
 	logger    *log.Logger
 	name      string
+	labels    map[string]string
 }
 
-func NewNamespace(clientset *kubernetes.Clientset, config *rest.Config, logger *log.Logger, name string) *Namespace {
+func NewNamespace(clientset *kubernetes.Clientset, config *rest.Config, logger *log.Logger, name string, labels map[string]string) *Namespace {
 	return &Namespace{
 		clientset: clientset,
 		config:    config,
 		logger:    logger,
 		name:      name,
+		labels:    labels,
 	}
 }
 
 func (p *Namespace) Create() error {
 	_, err := p.clientset.CoreV1().Namespaces().Create(context.TODO(), &apiv1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: p.name,
+			Name:   p.name,
+			Labels: p.labels,
 		},
 	}, metav1.CreateOptions{})
 	if err != nil {
@@ -0,0 +1,111 @@
+package testapis
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// ServiceAccount is a service account bound to a role with the given rules,
+// used to run the plugins with limited permissions.
+type ServiceAccount struct {
+	clientset *kubernetes.Clientset
+	config    *rest.Config
+	logger    *log.Logger
+	namespace string
+	name      string
+	rules     []rbacv1.PolicyRule
+}
+
+func NewServiceAccount(clientset *kubernetes.Clientset, config *rest.Config, logger *log.Logger, namespace string, name string, rules []rbacv1.PolicyRule) *ServiceAccount {
+	return &ServiceAccount{
+		clientset: clientset,
+		config:    config,
+		logger:    logger,
+		namespace: namespace,
+		name:      name,
+		rules:     rules,
+	}
+}
+
+func (p *ServiceAccount) Create() error {
+	meta := metav1.ObjectMeta{Name: p.name}
+
+	if _, err := p.clientset.CoreV1().ServiceAccounts(p.namespace).Create(context.TODO(), &apiv1.ServiceAccount{ObjectMeta: meta}, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create service account: %w", err)
+	}
+	if _, err := p.clientset.RbacV1().Roles(p.namespace).Create(context.TODO(), &rbacv1.Role{ObjectMeta: meta, Rules: p.rules}, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+	_, err := p.clientset.RbacV1().RoleBindings(p.namespace).Create(context.TODO(), &rbacv1.RoleBinding{
+		ObjectMeta: meta,
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      p.name,
+			Namespace: p.namespace,
+		}},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     p.name,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create role binding: %w", err)
+	}
+
+	p.logger.Printf("Service account \"%s\" created successfully in namespace \"%s\".\n", p.name, p.namespace)
+	return nil
+}
+
+// WriteKubeconfig writes to path a kubeconfig authenticating as the service
+// account with a token to the cluster of the test config.
+func (p *ServiceAccount) WriteKubeconfig(path string) error {
+	token, err := p.clientset.CoreV1().ServiceAccounts(p.namespace).CreateToken(context.TODO(), p.name, &authenticationv1.TokenRequest{}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create service account token: %w", err)
+	}
+
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[p.name] = &clientcmdapi.Cluster{
+		Server:                   p.config.Host,
+		CertificateAuthority:     p.config.CAFile,
+		CertificateAuthorityData: p.config.CAData,
+		InsecureSkipTLSVerify:    p.config.Insecure,
+	}
+	kubeconfig.AuthInfos[p.name] = &clientcmdapi.AuthInfo{Token: token.Status.Token}
+	kubeconfig.Contexts[p.name] = &clientcmdapi.Context{
+		Cluster:   p.name,
+		AuthInfo:  p.name,
+		Namespace: p.namespace,
+	}
+	kubeconfig.CurrentContext = p.name
+
+	if err := clientcmd.WriteToFile(*kubeconfig, path); err != nil {
+		return fmt.Errorf("failed to write kubeconfig: %w", err)
+	}
+	return nil
+}
+
+func (p *ServiceAccount) Delete() error {
+	if err := p.clientset.RbacV1().RoleBindings(p.namespace).Delete(context.TODO(), p.name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("failed to delete role binding: %w", err)
+	}
+	if err := p.clientset.RbacV1().Roles(p.namespace).Delete(context.TODO(), p.name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
+	if err := p.clientset.CoreV1().ServiceAccounts(p.namespace).Delete(context.TODO(), p.name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("failed to delete service account: %w", err)
+	}
+
+	return nil
+}